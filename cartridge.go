@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CartridgeHeader is the metadata at 0100-014F of every ROM.
+type CartridgeHeader struct {
+	Title        string
+	Manufacturer string // Four characters, only on later cartridges
+	CGBFlag      uint8  // 0x80 = CGB enhanced, 0xC0 = CGB only
+	SGB          bool   // Supports Super Game Boy functions
+	Type         CartridgeType
+	ROMSize      int // In bytes
+	RAMSize      int // External RAM in bytes, excluding MBC2's built-in RAM
+	Japanese     bool
+	Licensee     string // Two-character new licensee code, or the old code in hex
+	Version      uint8
+
+	HeaderChecksum uint8
+	GlobalChecksum uint16
+}
+
+// CartridgeType describes the hardware named by the type byte at 0147.
+type CartridgeType struct {
+	Code    uint8
+	Name    string
+	MBC     string // "ROM", "MBC1", "MBC2", "MBC3", "MBC5", ...
+	RAM     bool
+	Battery bool
+	Timer   bool
+	Rumble  bool
+}
+
+var cartridgeTypes = map[uint8]CartridgeType{
+	0x00: {Name: "ROM ONLY", MBC: "ROM"},
+	0x01: {Name: "MBC1", MBC: "MBC1"},
+	0x02: {Name: "MBC1+RAM", MBC: "MBC1", RAM: true},
+	0x03: {Name: "MBC1+RAM+BATTERY", MBC: "MBC1", RAM: true, Battery: true},
+	0x05: {Name: "MBC2", MBC: "MBC2", RAM: true},
+	0x06: {Name: "MBC2+BATTERY", MBC: "MBC2", RAM: true, Battery: true},
+	0x08: {Name: "ROM+RAM", MBC: "ROM", RAM: true},
+	0x09: {Name: "ROM+RAM+BATTERY", MBC: "ROM", RAM: true, Battery: true},
+	0x0B: {Name: "MMM01", MBC: "MMM01"},
+	0x0C: {Name: "MMM01+RAM", MBC: "MMM01", RAM: true},
+	0x0D: {Name: "MMM01+RAM+BATTERY", MBC: "MMM01", RAM: true, Battery: true},
+	0x0F: {Name: "MBC3+TIMER+BATTERY", MBC: "MBC3", Timer: true, Battery: true},
+	0x10: {Name: "MBC3+TIMER+RAM+BATTERY", MBC: "MBC3", Timer: true, RAM: true, Battery: true},
+	0x11: {Name: "MBC3", MBC: "MBC3"},
+	0x12: {Name: "MBC3+RAM", MBC: "MBC3", RAM: true},
+	0x13: {Name: "MBC3+RAM+BATTERY", MBC: "MBC3", RAM: true, Battery: true},
+	0x19: {Name: "MBC5", MBC: "MBC5"},
+	0x1A: {Name: "MBC5+RAM", MBC: "MBC5", RAM: true},
+	0x1B: {Name: "MBC5+RAM+BATTERY", MBC: "MBC5", RAM: true, Battery: true},
+	0x1C: {Name: "MBC5+RUMBLE", MBC: "MBC5", Rumble: true},
+	0x1D: {Name: "MBC5+RUMBLE+RAM", MBC: "MBC5", Rumble: true, RAM: true},
+	0x1E: {Name: "MBC5+RUMBLE+RAM+BATTERY", MBC: "MBC5", Rumble: true, RAM: true, Battery: true},
+	0x20: {Name: "MBC6", MBC: "MBC6"},
+	0x22: {Name: "MBC7+SENSOR+RUMBLE+RAM+BATTERY", MBC: "MBC7", Rumble: true, RAM: true, Battery: true},
+	0xFC: {Name: "POCKET CAMERA", MBC: "CAMERA"},
+	0xFD: {Name: "BANDAI TAMA5", MBC: "TAMA5"},
+	0xFE: {Name: "HuC3", MBC: "HuC3"},
+	0xFF: {Name: "HuC1+RAM+BATTERY", MBC: "HuC1", RAM: true, Battery: true},
+}
+
+// External RAM sizes indexed by the byte at 0149. Code 1 is unofficial but
+// appears in some homebrew.
+var ramSizes = []int{0, 2 * 1024, 8 * 1024, 32 * 1024, 128 * 1024, 64 * 1024}
+
+// parseCartridgeHeader decodes the header. It only fails when the ROM is
+// too short or the header names sizes or hardware that don't exist;
+// checksums are left to the caller.
+func parseCartridgeHeader(rom []byte) (*CartridgeHeader, error) {
+	if len(rom) < 0x150 {
+		return nil, fmt.Errorf("ROM is %d bytes, too short for a header", len(rom))
+	}
+
+	h := &CartridgeHeader{
+		CGBFlag:        rom[0x143],
+		SGB:            rom[0x146] == 0x03,
+		Japanese:       rom[0x14A] == 0x00,
+		Version:        rom[0x14C],
+		HeaderChecksum: rom[0x14D],
+		GlobalChecksum: uint16(rom[0x14E])<<8 | uint16(rom[0x14F]),
+	}
+
+	// The title originally filled 0134-0143. CGB-era cartridges took the
+	// last byte for the CGB flag and sometimes the four before it for a
+	// manufacturer code.
+	title := rom[0x134:0x144]
+	if h.CGBFlag&0x80 != 0 {
+		title = rom[0x134:0x143]
+		if code := rom[0x13F:0x143]; isManufacturerCode(code) {
+			title = rom[0x134:0x13F]
+			h.Manufacturer = string(code)
+		}
+	}
+	title, _, _ = bytes.Cut(title, []byte{0})
+	h.Title = strings.TrimRight(string(title), " ")
+
+	if rom[0x14B] == 0x33 {
+		h.Licensee = string(rom[0x144:0x146])
+	} else {
+		h.Licensee = fmt.Sprintf("%02X", rom[0x14B])
+	}
+
+	cartType, ok := cartridgeTypes[rom[0x147]]
+	if !ok {
+		return nil, fmt.Errorf("unknown cartridge type 0x%02X", rom[0x147])
+	}
+	cartType.Code = rom[0x147]
+	h.Type = cartType
+
+	if rom[0x148] > 8 {
+		return nil, fmt.Errorf("unknown ROM size code 0x%02X", rom[0x148])
+	}
+	h.ROMSize = 0x8000 << rom[0x148]
+
+	if int(rom[0x149]) >= len(ramSizes) {
+		return nil, fmt.Errorf("unknown RAM size code 0x%02X", rom[0x149])
+	}
+	h.RAMSize = ramSizes[rom[0x149]]
+
+	return h, nil
+}
+
+func isManufacturerCode(b []byte) bool {
+	for _, c := range b {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// headerChecksum computes the value the boot ROM checks against 014D.
+func headerChecksum(rom []byte) uint8 {
+	var sum uint8
+	for _, b := range rom[0x134:0x14D] {
+		sum = sum - b - 1
+	}
+	return sum
+}
+
+// globalChecksum sums every byte except the checksum itself. Real
+// hardware never checks it.
+func globalChecksum(rom []byte) uint16 {
+	var sum uint16
+	for i, b := range rom {
+		if i != 0x14E && i != 0x14F {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+// Validate checks the header against the ROM it came from. A bad header
+// checksum is an error, since the boot ROM would refuse to start; any
+// other problems are returned as warnings.
+func (h *CartridgeHeader) Validate(rom []byte) (warnings []string, err error) {
+	if sum := headerChecksum(rom); sum != h.HeaderChecksum {
+		return nil, fmt.Errorf("header checksum is 0x%02X, expected 0x%02X", h.HeaderChecksum, sum)
+	}
+	if sum := globalChecksum(rom); sum != h.GlobalChecksum {
+		warnings = append(warnings, fmt.Sprintf("global checksum is 0x%04X, expected 0x%04X", h.GlobalChecksum, sum))
+	}
+	if len(rom) < h.ROMSize {
+		warnings = append(warnings, fmt.Sprintf("ROM is %d bytes, header says %d", len(rom), h.ROMSize))
+	}
+	return warnings, nil
+}
+
+func (h *CartridgeHeader) String() string {
+	s := fmt.Sprintf("%q %s, %dKB ROM", h.Title, h.Type.Name, h.ROMSize/1024)
+	if h.RAMSize > 0 {
+		s += fmt.Sprintf(", %dKB RAM", h.RAMSize/1024)
+	}
+	return s
+}
+
+// Cartridge is the ROM and external RAM plugged into the cartridge slot,
+// along with whatever bank controller it has. The MMU forwards 0000-7FFF
+// and A000-BFFF to it.
+type Cartridge interface {
+	ReadROM(addr uint16) uint8
+	ReadRAM(addr uint16) uint8
+	// WriteRAM reports whether the write reached RAM. Writes while RAM is
+	// disabled, and writes to other registers mapped there such as the
+	// MBC3 clock, don't count.
+	WriteRAM(addr uint16, val uint8) bool
+	WriteRegister(addr uint16, val uint8) // Writes to 0000-7FFF
+
+	// SaveBattery returns the contents of battery-backed memory in the
+	// usual .sav layout, and LoadBattery restores it.
+	SaveBattery() []byte
+	LoadBattery(data []byte) error
+
+	// SaveState and LoadState serialize the RAM and bank registers.
+	SaveState(w io.Writer) error
+	LoadState(r io.Reader) error
+}
+
+// newCartridge returns the cartridge named by the header. ROMs with a
+// bad header or an unsupported controller are treated as having none.
+func newCartridge(rom []byte) Cartridge {
+	header, err := parseCartridgeHeader(rom)
+	if err != nil {
+		return newROMOnly(rom, 0)
+	}
+	switch header.Type.MBC {
+	case "MBC1":
+		return newMBC1(rom, header.RAMSize)
+	case "MBC2":
+		return newMBC2(rom)
+	case "MBC3":
+		return newMBC3(rom, header.RAMSize, header.Type.Timer)
+	case "MBC5":
+		return newMBC5(rom, header.RAMSize, header.Type.Rumble)
+	}
+	return newROMOnly(rom, header.RAMSize)
+}
+
+// ROMOnly is a cartridge without a bank controller: 32KB of ROM and at
+// most 8KB of RAM.
+type ROMOnly struct {
+	rom []byte
+	ram []byte
+}
+
+func newROMOnly(rom []byte, ramSize int) *ROMOnly {
+	return &ROMOnly{
+		rom: rom,
+		ram: make([]byte, min(ramSize, 0x2000)),
+	}
+}
+
+func (c *ROMOnly) ReadROM(addr uint16) uint8 {
+	return c.rom[addr]
+}
+
+func (c *ROMOnly) ReadRAM(addr uint16) uint8 {
+	if len(c.ram) == 0 {
+		return 0xFF
+	}
+	return c.ram[int(addr&0x1FFF)%len(c.ram)]
+}
+
+func (c *ROMOnly) WriteRAM(addr uint16, val uint8) bool {
+	if len(c.ram) == 0 {
+		return false
+	}
+	c.ram[int(addr&0x1FFF)%len(c.ram)] = val
+	return true
+}
+
+func (c *ROMOnly) WriteRegister(addr uint16, val uint8) {}
+
+func (c *ROMOnly) SaveBattery() []byte           { return bytes.Clone(c.ram) }
+func (c *ROMOnly) LoadBattery(data []byte) error { return loadBatteryRAM(c.ram, data) }
+func (c *ROMOnly) SaveState(w io.Writer) error   { return writeState(w, c.ram, nil) }
+func (c *ROMOnly) LoadState(r io.Reader) error   { return readState(r, c.ram, nil) }
+
+func loadBatteryRAM(ram, data []byte) error {
+	if len(data) != len(ram) {
+		return fmt.Errorf("save is %d bytes, cartridge has %d bytes of RAM", len(data), len(ram))
+	}
+	copy(ram, data)
+	return nil
+}
+
+// writeState writes a cartridge's RAM followed by its registers, which
+// must be a pointer to a fixed-size struct, or nil.
+func writeState(w io.Writer, ram []byte, regs any) error {
+	if _, err := w.Write(ram); err != nil {
+		return err
+	}
+	if regs == nil {
+		return nil
+	}
+	return binary.Write(w, binary.LittleEndian, regs)
+}
+
+func readState(r io.Reader, ram []byte, regs any) error {
+	if _, err := io.ReadFull(r, ram); err != nil {
+		return err
+	}
+	if regs == nil {
+		return nil
+	}
+	return binary.Read(r, binary.LittleEndian, regs)
+}
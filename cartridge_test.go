@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCartridgeHeaderTetris(t *testing.T) {
+	rom, err := os.ReadFile("roms/Tetris.gb")
+	if err != nil {
+		t.Skip(err)
+	}
+	h, err := parseCartridgeHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Title != "TETRIS" || h.Type.MBC != "ROM" || h.ROMSize != 0x8000 || h.RAMSize != 0 {
+		t.Errorf("got %v", h)
+	}
+	if h.Licensee != "01" || !h.Japanese || h.Version != 1 {
+		t.Errorf("licensee %s, Japanese %v, version %d", h.Licensee, h.Japanese, h.Version)
+	}
+	warnings, err := h.Validate(rom)
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("Validate: %v %v", warnings, err)
+	}
+}
+
+func newTestHeader(title string, cgb, cartType, romSize, ramSize uint8) []byte {
+	rom := make([]byte, 0x8000<<romSize)
+	copy(rom[0x134:], title)
+	rom[0x143] = cgb
+	rom[0x144], rom[0x145] = 'A', '4'
+	rom[0x147] = cartType
+	rom[0x148] = romSize
+	rom[0x149] = ramSize
+	rom[0x14A] = 0x01
+	rom[0x14B] = 0x33
+	rom[0x14D] = headerChecksum(rom)
+	sum := globalChecksum(rom)
+	rom[0x14E], rom[0x14F] = uint8(sum>>8), uint8(sum)
+	return rom
+}
+
+func TestCartridgeHeaderCGB(t *testing.T) {
+	rom := newTestHeader("POKEMON Y\x00\x00AAAE", 0x80, 0x1B, 2, 3)
+	h, err := parseCartridgeHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Title != "POKEMON Y" || h.Manufacturer != "AAAE" || h.CGBFlag != 0x80 {
+		t.Errorf("title %q, manufacturer %q, CGB flag %02X", h.Title, h.Manufacturer, h.CGBFlag)
+	}
+	if h.Type.Name != "MBC5+RAM+BATTERY" || !h.Type.Battery || h.ROMSize != 128*1024 || h.RAMSize != 32*1024 {
+		t.Errorf("got %v", h)
+	}
+	if h.Licensee != "A4" || h.Japanese {
+		t.Errorf("licensee %s, Japanese %v", h.Licensee, h.Japanese)
+	}
+}
+
+func TestCartridgeChecksums(t *testing.T) {
+	rom := newTestHeader("TEST", 0, 0x01, 1, 0)
+
+	rom[0x5000] ^= 0xFF
+	h, _ := parseCartridgeHeader(rom)
+	warnings, err := h.Validate(rom)
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "global checksum") {
+		t.Errorf("corrupt body: warnings %v, err %v", warnings, err)
+	}
+
+	rom[0x134] = 'X'
+	if _, err := h.Validate(rom); err == nil {
+		t.Error("corrupt header: expected an error")
+	}
+}
+
+func TestCartridgeHeaderErrors(t *testing.T) {
+	if _, err := parseCartridgeHeader(make([]byte, 0x100)); err == nil {
+		t.Error("short ROM: expected an error")
+	}
+	if _, err := parseCartridgeHeader(newTestHeader("TEST", 0, 0x42, 0, 0)); err == nil {
+		t.Error("unknown type: expected an error")
+	}
+}
+
+func TestCartridgeStateRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cartType uint8
+	}{
+		{"ROM+RAM", 0x08},
+		{"MBC1", 0x03},
+		{"MBC2", 0x06},
+		{"MBC3", 0x10},
+		{"MBC5", 0x1B},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rom := newBankedROM(tc.cartType, 3, 2)
+			cart := newCartridge(rom)
+			cart.WriteRegister(0x0000, 0x0A)
+			cart.WriteRegister(0x2100, 0x05)
+			cart.WriteRAM(0xA123, 0x0C)
+
+			var state bytes.Buffer
+			if err := cart.SaveState(&state); err != nil {
+				t.Fatal(err)
+			}
+			loaded := newCartridge(rom)
+			if err := loaded.LoadState(&state); err != nil {
+				t.Fatal(err)
+			}
+			if state.Len() != 0 {
+				t.Errorf("%d bytes left over", state.Len())
+			}
+			if got, want := loaded.ReadROM(0x4000), cart.ReadROM(0x4000); got != want {
+				t.Errorf("ROM bank %02X after loading, want %02X", got, want)
+			}
+			if got, want := loaded.ReadRAM(0xA123), cart.ReadRAM(0xA123); got != want {
+				t.Errorf("RAM read %02X after loading, want %02X", got, want)
+			}
+
+			battery := newCartridge(rom)
+			if err := battery.LoadBattery(cart.SaveBattery()); err != nil {
+				t.Fatal(err)
+			}
+			battery.WriteRegister(0x0000, 0x0A)
+			if got := battery.ReadRAM(0xA123) & 0x0F; got != 0x0C {
+				t.Errorf("RAM read %02X after loading the battery", got)
+			}
+		})
+	}
+}
+
+func TestMBC3BatteryFooter(t *testing.T) {
+	rom := newBankedROM(0x10, 3, 2)
+	data := newCartridge(rom).SaveBattery()
+	if len(data) != 0x2000+RTC_FOOTER_SIZE {
+		t.Errorf("save is %d bytes, want RAM plus the RTC footer", len(data))
+	}
+	if err := newCartridge(rom).LoadBattery(data[:0x2000]); err != nil {
+		t.Errorf("loading a save without a footer: %v", err)
+	}
+	if err := newCartridge(rom).LoadBattery(data[:0x2000+10]); err == nil {
+		t.Error("expected an error for a truncated footer")
+	}
+}
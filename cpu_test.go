@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// ramBus is 64KB of plain RAM with ROM below 8000, for running programs
+// without the rest of the system.
+type ramBus struct {
+	mem [0x10000]uint8
+}
+
+func (b *ramBus) Read(addr uint16) uint8 { return b.mem[addr] }
+
+func (b *ramBus) Write(addr uint16, val uint8) {
+	if addr >= 0x8000 {
+		b.mem[addr] = val
+	}
+}
+
+func (b *ramBus) Tick(cycles int) {}
+
+// benchmarkProgram fills 0100-0FFF with a fixed random mix of instructions
+// that neither branch nor write to memory, then jumps back to the start.
+func benchmarkProgram(bus *ramBus) {
+	rng := rand.New(rand.NewSource(1))
+	usable := func(op *opcode) bool {
+		m := op.mnemonic
+		if op.branchCycles != 0 || strings.HasPrefix(m, "ILLEGAL") {
+			return false
+		}
+		for _, prefix := range []string{"JP", "JR", "CALL", "RET", "RST", "PUSH", "POP", "HALT", "STOP", "LD [", "LDH [", "INC [", "DEC ["} {
+			if strings.HasPrefix(m, prefix) {
+				return false
+			}
+		}
+		return true
+	}
+
+	pc := 0x0100
+	for pc < 0x0FF0 {
+		code := uint8(rng.Intn(256))
+		if code == 0xCB {
+			cb := uint8(rng.Intn(256))
+			if strings.HasSuffix(cbOpcodes[cb].mnemonic, "[HL]") && !strings.HasPrefix(cbOpcodes[cb].mnemonic, "BIT") {
+				continue
+			}
+			bus.mem[pc], bus.mem[pc+1] = code, cb
+			pc += 2
+			continue
+		}
+		op := &opcodes[code]
+		if !usable(op) {
+			continue
+		}
+		bus.mem[pc] = code
+		for i := 1; i < int(op.length); i++ {
+			bus.mem[pc+i] = uint8(rng.Intn(256))
+		}
+		pc += int(op.length)
+	}
+	copy(bus.mem[pc:], []byte{0xC3, 0x00, 0x01}) // JP $0100
+}
+
+func BenchmarkStep(b *testing.B) {
+	bus := &ramBus{}
+	benchmarkProgram(bus)
+	cpu := newCPU(bus)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu.Step()
+	}
+}
+
+func TestStopIgnoresInterruptsUntilJoypad(t *testing.T) {
+	bus := &ramBus{}
+	copy(bus.mem[0x100:], []byte{0x10, 0x00, 0x00}) // STOP; NOP
+	bus.mem[0xFF00] = 0xFF                          // No input lines low
+	cpu := newCPU(bus)
+	cpu.Step()
+
+	// A joypad request left in IF from an earlier press must not wake it
+	cpu.Reg.IME = true
+	bus.mem[0xFFFF] = TIMER_INTERRUPT | JOYPAD_INTERRUPT
+	bus.mem[0xFF0F] = TIMER_INTERRUPT | JOYPAD_INTERRUPT
+	if n := cpu.HandleInterrupts(); n != 0 || !cpu.stopped || cpu.Reg.PC != 0x0102 {
+		t.Fatalf("dispatched while stopped: returned %d, stopped=%v PC=%04X", n, cpu.stopped, cpu.Reg.PC)
+	}
+	cpu.Step()
+	if !cpu.stopped || cpu.Reg.PC != 0x0102 {
+		t.Fatalf("woke without joypad input: stopped=%v PC=%04X", cpu.stopped, cpu.Reg.PC)
+	}
+
+	bus.mem[0xFF00] = 0xEE // Right held with the d-pad selected
+	cpu.Step()
+	if cpu.stopped || cpu.Reg.PC != 0x0103 {
+		t.Fatalf("stopped=%v PC=%04X after joypad input, want running at 0103", cpu.stopped, cpu.Reg.PC)
+	}
+	if n := cpu.HandleInterrupts(); n != 20 || cpu.Reg.PC != 0x0050 {
+		t.Errorf("returned %d with PC=%04X, want the timer interrupt at 0050", n, cpu.Reg.PC)
+	}
+}
+
+// TestOpcodeFlags runs every legal instruction from random states and
+// checks the table's flags column against F: '-' leaves the flag alone,
+// and '0' or '1' forces it.
+func TestOpcodeFlags(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	run := func(op *opcode, code ...uint8) {
+		for n := 0; n < 64; n++ {
+			bus := &ramBus{}
+			cpu := newCPU(bus)
+			r := cpu.Reg
+			r.A, r.B, r.C, r.D = uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			r.E, r.H, r.L = uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			r.F = uint8(rng.Intn(16)) << 4
+			r.SP = uint16(0xC000 + rng.Intn(0x1000))
+			r.PC = uint16(0xD000 + rng.Intn(0x1000))
+			copy(bus.mem[r.PC:], code)
+			for i := len(code); i < int(op.length); i++ {
+				bus.mem[int(r.PC)+i] = uint8(rng.Intn(256))
+			}
+
+			before := r.F
+			cpu.Step()
+			for i, c := range op.flags {
+				bit := ZERO_FLAG >> i
+				want := r.F & bit
+				switch c {
+				case '-':
+					want = before & bit
+				case '0':
+					want = 0
+				case '1':
+					want = bit
+				}
+				if r.F&bit != want {
+					t.Fatalf("%s (% X) with F=%02X: flag %c is %02X, want %02X from %q", op.mnemonic, code, before, "ZNHC"[i], r.F&bit, want, op.flags)
+				}
+			}
+		}
+	}
+
+	for i := range opcodes {
+		op := &opcodes[i]
+		if i == 0xCB || strings.HasPrefix(op.mnemonic, "ILLEGAL") {
+			continue
+		}
+		run(op, uint8(i))
+	}
+	for i := range cbOpcodes {
+		run(&cbOpcodes[i], 0xCB, uint8(i))
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Instruction is a single decoded instruction.
+type Instruction struct {
+	Addr  uint16
+	Bytes []uint8
+	Text  string // RGBDS syntax, e.g. "JR NZ, $0150"
+}
+
+// Disassembler decodes instructions from any memory reader, normally
+// MMU.Read. Operands that match a symbol are printed by name.
+type Disassembler struct {
+	Read    func(addr uint16) uint8
+	Bank    int      // ROM bank mapped at 4000-7FFF, for symbol lookups
+	Symbols *Symbols // Optional
+}
+
+func newDisassembler(read func(addr uint16) uint8, symbols *Symbols) *Disassembler {
+	return &Disassembler{
+		Read:    read,
+		Symbols: symbols,
+	}
+}
+
+// Disassemble decodes the instruction at addr.
+func (d *Disassembler) Disassemble(addr uint16) Instruction {
+	code := d.Read(addr)
+	op := &opcodes[code]
+	if code == 0xCB { // CB prefix
+		op = &cbOpcodes[d.Read(addr+1)]
+	}
+
+	inst := Instruction{Addr: addr}
+	for i := uint16(0); i < uint16(op.length); i++ {
+		inst.Bytes = append(inst.Bytes, d.Read(addr+i))
+	}
+
+	if op.mnemonic == "ILLEGAL" {
+		inst.Text = fmt.Sprintf("DB $%02X", code)
+		return inst
+	}
+
+	text := op.mnemonic
+	switch {
+	case strings.Contains(text, "n16"):
+		text = strings.Replace(text, "n16", d.immediate16(inst.Bytes), 1)
+	case strings.Contains(text, "a16"):
+		text = strings.Replace(text, "a16", d.address(d.immediate16Value(inst.Bytes)), 1)
+	case strings.Contains(text, "n8"):
+		text = strings.Replace(text, "n8", fmt.Sprintf("$%02X", inst.Bytes[1]), 1)
+	case strings.Contains(text, "a8"):
+		text = strings.Replace(text, "a8", d.address(0xFF00|uint16(inst.Bytes[1])), 1)
+	case strings.Contains(text, "SP+e8"):
+		text = strings.Replace(text, "+e8", signed(int8(inst.Bytes[1])), 1)
+	case strings.HasPrefix(text, "JR"):
+		// Relative jumps are shown with their resolved target
+		target := uint16(int32(addr) + int32(op.length) + int32(int8(inst.Bytes[1])))
+		text = strings.Replace(text, "e8", d.address(target), 1)
+	case strings.Contains(text, "e8"):
+		text = strings.Replace(text, "e8", strconv.Itoa(int(int8(inst.Bytes[1]))), 1)
+	case strings.HasPrefix(text, "RST"):
+		if label, ok := d.Symbols.Lookup(0, uint16(code&0x38)); ok {
+			text = "RST " + label
+		}
+	}
+
+	inst.Text = text
+	return inst
+}
+
+func (d *Disassembler) immediate16Value(b []uint8) uint16 {
+	return uint16(b[2])<<8 | uint16(b[1])
+}
+
+func (d *Disassembler) immediate16(b []uint8) string {
+	val := d.immediate16Value(b)
+	if label, ok := d.Symbols.Lookup(d.Bank, val); ok {
+		return label
+	}
+	return fmt.Sprintf("$%04X", val)
+}
+
+// address formats a memory operand, preferring a symbol and then a
+// hardware register name.
+func (d *Disassembler) address(addr uint16) string {
+	if label, ok := d.Symbols.Lookup(d.Bank, addr); ok {
+		return label
+	}
+	if name, ok := ioRegisterNames[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// signed formats an SP offset the way RGBDS writes it, e.g. "SP-2".
+func signed(offset int8) string {
+	if offset < 0 {
+		return strconv.Itoa(int(offset))
+	}
+	return "+" + strconv.Itoa(int(offset))
+}
+
+// Dump writes the instructions in [start, end] as an RGBDS-style
+// listing, with labels on their own lines and the address and raw bytes
+// of each instruction in a trailing comment.
+func (d *Disassembler) Dump(w io.Writer, start, end uint16) error {
+	addr := uint32(start)
+	for addr <= uint32(end) {
+		inst := d.Disassemble(uint16(addr))
+		if label, ok := d.Symbols.Lookup(d.Bank, inst.Addr); ok {
+			if _, err := fmt.Fprintf(w, "%s:\n", label); err != nil {
+				return err
+			}
+		}
+
+		raw := make([]string, len(inst.Bytes))
+		for i, b := range inst.Bytes {
+			raw[i] = fmt.Sprintf("%02X", b)
+		}
+		_, err := fmt.Fprintf(w, "\t%-24s ; %02X:%04X %s\n", inst.Text, d.symbolBank(inst.Addr), inst.Addr, strings.Join(raw, " "))
+		if err != nil {
+			return err
+		}
+		addr += uint32(len(inst.Bytes))
+	}
+	return nil
+}
+
+func (d *Disassembler) symbolBank(addr uint16) int {
+	if addr >= 0x4000 && addr < 0x8000 {
+		return d.Bank
+	}
+	return 0
+}
+
+// Symbols maps addresses to labels, as loaded from the .sym files written
+// by RGBDS and no$gmb.
+type Symbols struct {
+	labels map[uint32]string
+}
+
+// LoadSymbols parses "BB:AAAA Label" lines. Blank lines and comments
+// starting with ';' are ignored.
+func LoadSymbols(r io.Reader) (*Symbols, error) {
+	s := &Symbols{labels: make(map[uint32]string)}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"BB:AAAA Label\"", lineNum)
+		}
+
+		bankStr, addrStr, ok := strings.Cut(fields[0], ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing bank separator", lineNum)
+		}
+		bank, err := strconv.ParseUint(bankStr, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad bank: %v", lineNum, err)
+		}
+		addr, err := strconv.ParseUint(addrStr, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad address: %v", lineNum, err)
+		}
+
+		key := symbolKey(int(bank), uint16(addr))
+		if _, exists := s.labels[key]; !exists { // Keep the first label
+			s.labels[key] = fields[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lookup returns the label at addr. bank selects the ROM bank for
+// addresses in 4000-7FFF and is ignored elsewhere. A nil *Symbols has
+// no labels.
+func (s *Symbols) Lookup(bank int, addr uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	if addr < 0x4000 || addr >= 0x8000 {
+		bank = 0
+	}
+	label, ok := s.labels[symbolKey(bank, addr)]
+	return label, ok
+}
+
+func symbolKey(bank int, addr uint16) uint32 {
+	return uint32(bank)<<16 | uint32(addr)
+}
+
+// ioRegisterNames uses the names from hardware.inc for the memory-mapped
+// registers that code most often touches.
+var ioRegisterNames = map[uint16]string{
+	0xFF00: "rP1",
+	0xFF01: "rSB",
+	0xFF02: "rSC",
+	0xFF04: "rDIV",
+	0xFF05: "rTIMA",
+	0xFF06: "rTMA",
+	0xFF07: "rTAC",
+	0xFF0F: "rIF",
+	0xFF10: "rNR10",
+	0xFF11: "rNR11",
+	0xFF12: "rNR12",
+	0xFF13: "rNR13",
+	0xFF14: "rNR14",
+	0xFF16: "rNR21",
+	0xFF17: "rNR22",
+	0xFF18: "rNR23",
+	0xFF19: "rNR24",
+	0xFF1A: "rNR30",
+	0xFF1B: "rNR31",
+	0xFF1C: "rNR32",
+	0xFF1D: "rNR33",
+	0xFF1E: "rNR34",
+	0xFF20: "rNR41",
+	0xFF21: "rNR42",
+	0xFF22: "rNR43",
+	0xFF23: "rNR44",
+	0xFF24: "rNR50",
+	0xFF25: "rNR51",
+	0xFF26: "rNR52",
+	0xFF40: "rLCDC",
+	0xFF41: "rSTAT",
+	0xFF42: "rSCY",
+	0xFF43: "rSCX",
+	0xFF44: "rLY",
+	0xFF45: "rLYC",
+	0xFF46: "rDMA",
+	0xFF47: "rBGP",
+	0xFF48: "rOBP0",
+	0xFF49: "rOBP1",
+	0xFF4A: "rWY",
+	0xFF4B: "rWX",
+	0xFF50: "rBANK",
+	0xFFFF: "rIE",
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testSymbols = `; Comments and blank lines are skipped
+
+00:0038 Crash
+00:0150 Start
+00:0150 StartAlias ; Duplicates keep the first label
+01:4000 BankOne
+02:4000 BankTwo
+00:C000 wBuffer
+`
+
+func loadTestSymbols(t *testing.T) *Symbols {
+	t.Helper()
+	symbols, err := LoadSymbols(strings.NewReader(testSymbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return symbols
+}
+
+func TestDisassemble(t *testing.T) {
+	symbols := loadTestSymbols(t)
+	for _, tc := range []struct {
+		addr    uint16
+		code    []uint8
+		symbols bool
+		want    string
+	}{
+		{0x0200, []uint8{0x01, 0x34, 0x12}, false, "LD BC, $1234"},
+		{0x0200, []uint8{0x3E, 0x7F}, false, "LD A, $7F"},
+		{0x0200, []uint8{0xEA, 0x00, 0xC1}, false, "LD [$C100], A"},
+		{0x0200, []uint8{0xFA, 0x44, 0xFF}, false, "LD A, [rLY]"},
+		{0x0200, []uint8{0xE0, 0x40}, false, "LDH [rLCDC], A"},
+		{0x0200, []uint8{0xF0, 0x80}, false, "LDH A, [$FF80]"},
+		{0x0200, []uint8{0xF8, 0xFE}, false, "LD HL, SP-2"},
+		{0x0200, []uint8{0xF8, 0x05}, false, "LD HL, SP+5"},
+		{0x0200, []uint8{0xE8, 0xFC}, false, "ADD SP, -4"},
+		{0x0200, []uint8{0x18, 0xFE}, false, "JR $0200"},
+		{0x0200, []uint8{0x20, 0x05}, false, "JR NZ, $0207"},
+		{0x0200, []uint8{0xCB, 0x7C}, false, "BIT 7, H"},
+		{0x0200, []uint8{0xCB, 0x37}, false, "SWAP A"},
+		{0x0200, []uint8{0xD3}, false, "DB $D3"},
+		{0x0200, []uint8{0xFF}, false, "RST $38"},
+		{0x0200, []uint8{0xC3, 0x50, 0x01}, true, "JP Start"},
+		{0x0200, []uint8{0x21, 0x00, 0xC0}, true, "LD HL, wBuffer"},
+		{0x0200, []uint8{0xFF}, true, "RST Crash"},
+		{0x0140, []uint8{0x18, 0x0E}, true, "JR Start"},
+		{0x0200, []uint8{0xCD, 0x00, 0x40}, true, "CALL BankTwo"}, // Bank 2 mapped
+	} {
+		var mem [0x10000]uint8
+		copy(mem[tc.addr:], tc.code)
+		d := newDisassembler(func(addr uint16) uint8 { return mem[addr] }, nil)
+		if tc.symbols {
+			d.Symbols = symbols
+			d.Bank = 2
+		}
+
+		inst := d.Disassemble(tc.addr)
+		if inst.Text != tc.want || !bytes.Equal(inst.Bytes, tc.code) || inst.Addr != tc.addr {
+			t.Errorf("% X: got %q with bytes % X, want %q", tc.code, inst.Text, inst.Bytes, tc.want)
+		}
+	}
+}
+
+func TestSymbolsLookup(t *testing.T) {
+	symbols := loadTestSymbols(t)
+	for _, tc := range []struct {
+		bank int
+		addr uint16
+		want string
+	}{
+		{0, 0x0150, "Start"},
+		{5, 0x0150, "Start"}, // Bank only applies to 4000-7FFF
+		{1, 0x4000, "BankOne"},
+		{2, 0x4000, "BankTwo"},
+		{3, 0x4000, ""},
+		{7, 0xC000, "wBuffer"},
+		{0, 0x0151, ""},
+	} {
+		got, ok := symbols.Lookup(tc.bank, tc.addr)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("Lookup(%d, %04X) = %q, %v, want %q", tc.bank, tc.addr, got, ok, tc.want)
+		}
+	}
+
+	var none *Symbols
+	if _, ok := none.Lookup(0, 0x0150); ok {
+		t.Error("nil Symbols found a label")
+	}
+}
+
+func TestLoadSymbolsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input, want string
+	}{
+		{"00:0150 Start\n000150 Next", "line 2: missing bank separator"},
+		{"XY:0150 Start", "line 1: bad bank"},
+		{"00:01G0 Start", "line 1: bad address"},
+		{"00:0150", "line 1: expected"},
+		{"00:0150 Start Extra", "line 1: expected"},
+	} {
+		_, err := LoadSymbols(strings.NewReader(tc.input))
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%q: got error %v, want %q", tc.input, err, tc.want)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	var mem [0x10000]uint8
+	copy(mem[0x0150:], []uint8{0x00, 0xC3, 0x50, 0x01})
+	copy(mem[0x4000:], []uint8{0xC9})
+	d := newDisassembler(func(addr uint16) uint8 { return mem[addr] }, loadTestSymbols(t))
+	d.Bank = 1
+
+	var out strings.Builder
+	if err := d.Dump(&out, 0x0150, 0x0152); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Dump(&out, 0x4000, 0x4000); err != nil {
+		t.Fatal(err)
+	}
+	want := "Start:\n" +
+		"\tNOP                      ; 00:0150 00\n" +
+		"\tJP Start                 ; 00:0151 C3 50 01\n" +
+		"BankOne:\n" +
+		"\tRET                      ; 01:4000 C9\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"time"
 	"unsafe"
 
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+// Shades, as the palette registers select them
 var colorMap = map[uint8]uint32{
 	0: 0xFFFFFFFF, // White
 	1: 0xFFAAAAAA, // Light gray
@@ -14,9 +16,23 @@ var colorMap = map[uint8]uint32{
 	3: 0xFF000000, // Black
 }
 
+// Keyboard layout for the eight inputs
+var keyMap = map[sdl.Scancode]Button{
+	sdl.SCANCODE_RIGHT:     BUTTON_RIGHT,
+	sdl.SCANCODE_LEFT:      BUTTON_LEFT,
+	sdl.SCANCODE_UP:        BUTTON_UP,
+	sdl.SCANCODE_DOWN:      BUTTON_DOWN,
+	sdl.SCANCODE_Z:         BUTTON_A,
+	sdl.SCANCODE_X:         BUTTON_B,
+	sdl.SCANCODE_BACKSPACE: BUTTON_SELECT,
+	sdl.SCANCODE_RETURN:    BUTTON_START,
+}
+
 type Game struct {
+	mmu        *MMU
 	ppu        *PPU
 	cpu        *CPU
+	save       *BatterySave // nil for cartridges without a battery
 	cycleCount int
 
 	window      *sdl.Window
@@ -24,9 +40,10 @@ type Game struct {
 	texture     *sdl.Texture
 	pixelBuffer []uint32
 	running     bool
+	reportedErr bool
 }
 
-func newGame(ppu *PPU, cpu *CPU) *Game {
+func newGame(mmu *MMU, ppu *PPU, cpu *CPU) *Game {
 	sdl.Init(sdl.INIT_VIDEO)
 	window, _ := sdl.CreateWindow(
 		"Gameboy Emulator",
@@ -46,6 +63,7 @@ func newGame(ppu *PPU, cpu *CPU) *Game {
 	)
 
 	return &Game{
+		mmu:         mmu,
 		cpu:         cpu,
 		ppu:         ppu,
 		window:      window,
@@ -56,35 +74,59 @@ func newGame(ppu *PPU, cpu *CPU) *Game {
 	}
 }
 
-// Handles Quit Detection
+// Handles Quit Detection and reads the keyboard into the joypad
 func (g *Game) handleEvents() {
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 		if _, ok := event.(*sdl.QuitEvent); ok {
 			g.running = false
 		}
 	}
+
+	keys := sdl.GetKeyboardState()
+	for scancode, button := range keyMap {
+		g.mmu.SetButton(button, keys[scancode] != 0)
+	}
 }
 
 func (g *Game) update() {
-	for g.cycleCount < 70224 {
-		cycles := g.cpu.Step()
-		g.cycleCount += cycles
-		g.cpu.mmu.UpdateScanline(cycles) // Update LY register
+	// Run until the PPU finishes a frame. With the LCD off there are no
+	// frames, so stop after a frame's worth of cycles instead. The limit
+	// with it on only guards against the clock being stopped.
+	limit := 70224
+	if g.ppu.lcdOn {
+		limit *= 2
+	}
+	g.ppu.frameDone = false
+	for !g.ppu.frameDone && g.cycleCount < limit {
+		// The CPU ticks the MMU, and through it the PPU, on every
+		// memory access
+		g.cycleCount += g.cpu.Step()
 
 		// Check for interrupts after each instruction
 		intCycles := g.cpu.HandleInterrupts()
 		g.cycleCount += intCycles
 	}
+	g.cycleCount = 0
 
-	g.ppu.Render()
-	g.cycleCount -= 70224
+	if g.save != nil {
+		if err := g.save.Update(time.Now()); err != nil {
+			fmt.Printf("Error saving: %v\n", err)
+		}
+	}
+
+	// A locked-up CPU leaves the window open so its state can be inspected
+	if err := g.cpu.Err(); err != nil && !g.reportedErr {
+		fmt.Printf("Error: %v\n", err)
+		g.window.SetTitle("Gameboy Emulator - " + err.Error())
+		g.reportedErr = true
+	}
 }
 
 func (g *Game) draw() {
 	for y := 0; y < 144; y++ {
 		for x := 0; x < 160; x++ {
-			pixelVal := g.ppu.framebuffer[y][x]
-			g.pixelBuffer[y*160+x] = colorMap[pixelVal]
+			shade := g.ppu.framebuffer[y][x]
+			g.pixelBuffer[y*160+x] = colorMap[shade]
 		}
 	}
 
@@ -0,0 +1,59 @@
+package main
+
+// Button is one of the eight inputs. The low nibble is the d-pad and the
+// high nibble the buttons, each in the bit order P1 reports them in.
+type Button uint8
+
+const (
+	BUTTON_RIGHT  Button = 0x01
+	BUTTON_LEFT   Button = 0x02
+	BUTTON_UP     Button = 0x04
+	BUTTON_DOWN   Button = 0x08
+	BUTTON_A      Button = 0x10
+	BUTTON_B      Button = 0x20
+	BUTTON_SELECT Button = 0x40
+	BUTTON_START  Button = 0x80
+)
+
+// Joypad is the P1 register (FF00). The game selects the d-pad, the
+// buttons or both with bits 4-5, then reads the selected inputs from
+// bits 0-3. Everything is active low.
+type Joypad struct {
+	pressed Button
+	selects uint8 // Bits 4-5 as last written
+}
+
+// lines returns P10-P13, which are low for each pressed input in a
+// selected group.
+func (j *Joypad) lines() uint8 {
+	lines := uint8(0x0F)
+	if j.selects&0x10 == 0 {
+		lines &^= uint8(j.pressed) & 0x0F
+	}
+	if j.selects&0x20 == 0 {
+		lines &^= uint8(j.pressed) >> 4
+	}
+	return lines
+}
+
+func (j *Joypad) read() uint8 {
+	return 0xC0 | j.selects | j.lines()
+}
+
+// write and setButton report whether any line went from high to low,
+// which requests the joypad interrupt.
+func (j *Joypad) write(val uint8) (interrupt bool) {
+	before := j.lines()
+	j.selects = val & 0x30
+	return before&^j.lines() != 0
+}
+
+func (j *Joypad) setButton(b Button, pressed bool) (interrupt bool) {
+	before := j.lines()
+	if pressed {
+		j.pressed |= b
+	} else {
+		j.pressed &^= b
+	}
+	return before&^j.lines() != 0
+}
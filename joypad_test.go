@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestJoypadSelect(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	m.SetButton(BUTTON_LEFT, true)
+	m.SetButton(BUTTON_START, true)
+
+	for _, tc := range []struct {
+		selects, want uint8
+	}{
+		{0x30, 0xFF}, // Nothing selected
+		{0x20, 0xED}, // D-pad: Left
+		{0x10, 0xD7}, // Buttons: Start
+		{0x00, 0xC5}, // Both
+	} {
+		m.Write(0xFF00, tc.selects)
+		if got := m.Read(0xFF00); got != tc.want {
+			t.Errorf("P1 with %02X written read %02X, want %02X", tc.selects, got, tc.want)
+		}
+	}
+}
+
+func TestJoypadInterrupt(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	m.io[0x0F] = 0
+
+	m.Write(0xFF00, 0x20) // D-pad only
+	m.SetButton(BUTTON_A, true)
+	if m.io[0x0F]&JOYPAD_INTERRUPT != 0 {
+		t.Error("interrupt for an unselected button")
+	}
+
+	// Selecting the buttons pulls the A line low
+	m.Write(0xFF00, 0x10)
+	if m.io[0x0F]&JOYPAD_INTERRUPT == 0 {
+		t.Error("no interrupt when selecting a held button")
+	}
+
+	m.io[0x0F] = 0
+	m.SetButton(BUTTON_A, false)
+	m.SetButton(BUTTON_B, true)
+	if m.io[0x0F]&JOYPAD_INTERRUPT == 0 {
+		t.Error("no interrupt on press")
+	}
+}
+
+func TestJoypadWakesFromStop(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{0x10, 0x00, 0x3C}) // STOP; INC A
+	m := newMMU(rom)
+	cpu := newCPU(m)
+
+	// An earlier press leaves the joypad bit set in IF
+	m.Write(0xFF00, 0x10)
+	m.SetButton(BUTTON_A, true)
+	m.SetButton(BUTTON_A, false)
+	if m.io[0x0F]&JOYPAD_INTERRUPT == 0 {
+		t.Fatal("no interrupt on press")
+	}
+
+	cpu.Step()
+	cpu.Step()
+	if !cpu.stopped || cpu.Reg.PC != 0x0102 {
+		t.Fatalf("stopped=%v PC=%04X, want stopped at 0102", cpu.stopped, cpu.Reg.PC)
+	}
+
+	// Only the d-pad is selected, so Start does not reach P1
+	m.Write(0xFF00, 0x20)
+	m.SetButton(BUTTON_START, true)
+	cpu.Step()
+	if !cpu.stopped {
+		t.Fatal("woke on an unselected button")
+	}
+
+	m.Write(0xFF00, 0x10)
+	cpu.Step()
+	if cpu.stopped || cpu.Reg.A != 0x02 {
+		t.Errorf("stopped=%v A=%02X after selecting the held Start, want running and 02", cpu.stopped, cpu.Reg.A)
+	}
+}
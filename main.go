@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
 func loadROM(filename string) ([]byte, error) {
@@ -12,48 +16,237 @@ func loadROM(filename string) ([]byte, error) {
 	}
 
 	// Pad ROM to 32KB minimum
-	rom := make([]byte, 0x8000)
+	rom := make([]byte, max(len(data), 0x8000))
 	copy(rom, data)
 	return rom, nil
 }
 
+// checkCartridge parses and validates the header, printing any warnings.
+func checkCartridge(rom []byte) (*CartridgeHeader, error) {
+	header, err := parseCartridgeHeader(rom)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := header.Validate(rom)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	switch header.Type.MBC {
+	case "ROM", "MBC1", "MBC2", "MBC3", "MBC5":
+	default:
+		fmt.Printf("Warning: %s cartridges are not supported yet\n", header.Type.MBC)
+	}
+	return header, nil
+}
+
+// disasmMain implements "disasm [flags] rom.gb", which prints a listing
+// of one ROM bank or a range of banks.
+func disasmMain(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	banks := flags.String("bank", "0", "ROM bank to dump, or an inclusive range such as 1-3")
+	start := flags.Uint("start", 0, "first address within each bank window (default: start of window)")
+	end := flags.Uint("end", 0, "last address within each bank window (default: end of window)")
+	symFile := flags.String("sym", "", "RGBDS or no$gmb .sym file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gameboy disasm [flags] rom.gb")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	rom, err := loadROM(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	first, last, err := parseBankRange(*banks)
+	if err != nil {
+		return err
+	}
+	if numBanks := len(rom) / 0x4000; last >= numBanks {
+		return fmt.Errorf("bank %d out of range, ROM has %d banks", last, numBanks)
+	}
+
+	var symbols *Symbols
+	if *symFile != "" {
+		f, err := os.Open(*symFile)
+		if err != nil {
+			return err
+		}
+		symbols, err = LoadSymbols(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", *symFile, err)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for bank := first; bank <= last; bank++ {
+		// Bank 0 is always mapped at 0000-3FFF, the others at 4000-7FFF
+		lo, hi := uint16(0x4000), uint16(0x7FFF)
+		if bank == 0 {
+			lo, hi = 0x0000, 0x3FFF
+		}
+		if *start != 0 {
+			lo = max(lo, uint16(*start))
+		}
+		if *end != 0 {
+			hi = min(hi, uint16(*end))
+		}
+
+		d := newDisassembler(bankReader(rom, bank), symbols)
+		d.Bank = bank
+		fmt.Fprintf(out, "; ROM bank %d\n", bank)
+		if err := d.Dump(out, lo, hi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseBankRange(s string) (first, last int, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if first, err = strconv.Atoi(lo); err != nil {
+		return 0, 0, fmt.Errorf("bad bank %q", s)
+	}
+	last = first
+	if isRange {
+		if last, err = strconv.Atoi(hi); err != nil || last < first {
+			return 0, 0, fmt.Errorf("bad bank range %q", s)
+		}
+	}
+	return first, last, nil
+}
+
+// bankReader reads ROM as if the given bank were switched in at 4000-7FFF.
+func bankReader(rom []byte, bank int) func(addr uint16) uint8 {
+	return func(addr uint16) uint8 {
+		offset := int(addr)
+		if addr >= 0x4000 {
+			offset = bank*0x4000 + int(addr-0x4000)
+		}
+		if offset < len(rom) {
+			return rom[offset]
+		}
+		return 0xFF
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		if err := disasmMain(os.Args[2:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	bootROMFile := flag.String("bootrom", "", "run this 256-byte DMG boot ROM before the cartridge")
+	traceFile := flag.String("trace", "", "write a gameboy-doctor style instruction trace to this file")
+	traceFrom := flag.Uint("trace-from", 0, "only trace instructions at or above this PC")
+	traceTo := flag.Uint("trace-to", 0xFFFF, "only trace instructions at or below this PC")
+	traceLimit := flag.Int("trace-limit", 0, "stop tracing after this many instructions (0 = no limit)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: gameboy [flags] [rom.gb]\n       gameboy disasm [flags] rom.gb")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	romPath := flag.Arg(0)
+
 	fmt.Println("GameBoy emulator starting...")
 
 	var rom []byte
 	var err error
 
-	if len(os.Args) > 1 {
-		fmt.Printf("Loading ROM: %s\n", os.Args[1])
-		rom, err = loadROM(os.Args[1])
+	if romPath != "" {
+		fmt.Printf("Loading ROM: %s\n", romPath)
+		rom, err = loadROM(romPath)
 		if err != nil {
 			fmt.Printf("Error loading ROM: %v\n", err)
 			rom = nil
 		}
 	}
 
+	var header *CartridgeHeader
+	if rom != nil {
+		header, err = checkCartridge(rom)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Cartridge: %v\n", header)
+	}
+
 	if rom == nil {
 		fmt.Println("Using test ROM...")
 		testRom := []byte{
-			0x3E, 0x01, // 0x00: LD A, 1
-			0x06, 0x00, // 0x02: LD B, 0
-			0x80,       // 0x04: ADD A, B (A = A + B)
-			0x47,       // 0x05: LD B, A  (Copy result to B)
-			0x18, 0xFC, // 0x06: JR -4    (Jump back to 0x04)
+			0x3E, 0x01, // 0x100: LD A, 1
+			0x06, 0x00, // 0x102: LD B, 0
+			0x80,       // 0x104: ADD A, B (A = A + B)
+			0x47,       // 0x105: LD B, A  (Copy result to B)
+			0x18, 0xFC, // 0x106: JR -4    (Jump back to 0x104)
 		}
 
+		// Placed at the cartridge entry point, where the CPU starts
 		rom = make([]byte, 0x8000)
-		copy(rom, testRom)
+		copy(rom[0x100:], testRom)
 	}
 
 	mmu := newMMU(rom)
-	ppu := newPPU(mmu)
+	ppu := mmu.ppu
 	cpu := newCPU(mmu)
-	game := newGame(ppu, cpu)
+
+	if *bootROMFile != "" {
+		boot, err := os.ReadFile(*bootROMFile)
+		if err == nil {
+			err = mmu.mapBootROM(boot)
+		}
+		if err != nil {
+			fmt.Printf("Error loading boot ROM: %v\n", err)
+			os.Exit(1)
+		}
+		*cpu.Reg = Registers{} // The boot ROM starts from zeroed registers at 0000
+	}
+
+	// A save that fails to load is left alone rather than overwritten
+	var save *BatterySave
+	if header != nil && header.Type.Battery {
+		save, err = openBatterySave(savePath(romPath), mmu)
+		if err != nil {
+			fmt.Printf("Error loading save: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	game := newGame(mmu, ppu, cpu)
+	game.save = save
 	defer game.cleanup()
 
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			fmt.Printf("Error creating trace file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+
+		cpu.tracer = newTracer(w)
+		cpu.tracer.MinPC = uint16(*traceFrom)
+		cpu.tracer.MaxPC = uint16(*traceTo)
+		cpu.tracer.Limit = *traceLimit
+	}
+
 	// Only initialize test pattern if using test ROM
-	if len(os.Args) <= 1 {
+	if romPath == "" {
 		// Tile 0: Vertical stripes (alternating white/black columns)
 		// Each row: 0x00 (low bits all 0), 0xFF (high bits all 1) = pattern 2,2,2,2,2,2,2,2
 		// Then:     0xFF (low bits all 1), 0x00 (high bits all 0) = pattern 1,1,1,1,1,1,1,1
@@ -101,5 +294,10 @@ func main() {
 	}
 
 	game.run()
+	if game.save != nil {
+		if err := game.save.Flush(); err != nil {
+			fmt.Printf("Error saving: %v\n", err)
+		}
+	}
 	fmt.Println("Gameboy emulator stopping...")
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// MBC1 supports up to 2MB of ROM and 32KB of RAM. Two registers select
+// the bank: a 5-bit register for ROM and a 2-bit register that supplies
+// either ROM bank bits 5-6 or the RAM bank.
+type MBC1 struct {
+	rom []byte
+	ram []byte
+
+	ramEnabled bool
+	bank1      uint8 // 2000-3FFF, never 0
+	bank2      uint8 // 4000-5FFF
+	mode       uint8 // 6000-7FFF; 1 also applies bank2 to 0000-3FFF and RAM
+
+	// Multicarts wire bank2 to bits 4-5 instead of 5-6
+	multicart bool
+}
+
+func newMBC1(rom []byte, ramSize int) *MBC1 {
+	return &MBC1{
+		rom:       rom,
+		ram:       make([]byte, ramSize),
+		bank1:     1,
+		multicart: isMBC1Multicart(rom),
+	}
+}
+
+// isMBC1Multicart detects MBC1M collections, which are always 1MB and
+// start each 256KB game with its own header. There's no header flag, so
+// look for the Nintendo logo at the start of the second game.
+func isMBC1Multicart(rom []byte) bool {
+	if len(rom) != 0x100000 {
+		return false
+	}
+	logo := rom[0x104:0x134]
+	return bytes.Equal(rom[0x40104:0x40134], logo)
+}
+
+func (m *MBC1) romBank(addr uint16) int {
+	shift, low := 5, m.bank1
+	if m.multicart {
+		shift, low = 4, m.bank1&0x0F
+	}
+
+	bank := 0
+	if addr >= 0x4000 {
+		bank = int(m.bank2)<<shift | int(low)
+	} else if m.mode == 1 {
+		// Banks 0x20, 0x40 and 0x60 show up in the bank 0 window
+		bank = int(m.bank2) << shift
+	}
+	return bank % (len(m.rom) / 0x4000)
+}
+
+func (m *MBC1) ReadROM(addr uint16) uint8 {
+	return m.rom[m.romBank(addr)*0x4000+int(addr&0x3FFF)]
+}
+
+func (m *MBC1) ramOffset(addr uint16) int {
+	bank := 0
+	if m.mode == 1 {
+		bank = int(m.bank2)
+	}
+	return (bank*0x2000 + int(addr&0x1FFF)) % len(m.ram)
+}
+
+func (m *MBC1) ReadRAM(addr uint16) uint8 {
+	if !m.ramEnabled || len(m.ram) == 0 {
+		return 0xFF
+	}
+	return m.ram[m.ramOffset(addr)]
+}
+
+func (m *MBC1) WriteRAM(addr uint16, val uint8) bool {
+	if !m.ramEnabled || len(m.ram) == 0 {
+		return false
+	}
+	m.ram[m.ramOffset(addr)] = val
+	return true
+}
+
+func (m *MBC1) WriteRegister(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+	case addr < 0x4000:
+		// Only a zero here is remapped, so banks 0x20, 0x40 and 0x60 can't
+		// be selected in the 4000-7FFF window and read as the bank after
+		m.bank1 = val & 0x1F
+		if m.bank1 == 0 {
+			m.bank1 = 1
+		}
+	case addr < 0x6000:
+		m.bank2 = val & 0x03
+	default:
+		m.mode = val & 0x01
+	}
+}
+
+// mbc1State is what SaveState writes after the RAM.
+type mbc1State struct {
+	RAMEnabled         bool
+	Bank1, Bank2, Mode uint8
+}
+
+func (m *MBC1) SaveBattery() []byte           { return bytes.Clone(m.ram) }
+func (m *MBC1) LoadBattery(data []byte) error { return loadBatteryRAM(m.ram, data) }
+
+func (m *MBC1) SaveState(w io.Writer) error {
+	return writeState(w, m.ram, &mbc1State{m.ramEnabled, m.bank1, m.bank2, m.mode})
+}
+
+func (m *MBC1) LoadState(r io.Reader) error {
+	var s mbc1State
+	if err := readState(r, m.ram, &s); err != nil {
+		return err
+	}
+	m.ramEnabled, m.bank1, m.bank2, m.mode = s.RAMEnabled, s.Bank1, s.Bank2, s.Mode
+	return nil
+}
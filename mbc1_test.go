@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+// newBankedROM builds a ROM with the given header whose banks each start
+// with their own bank number.
+func newBankedROM(cartType, romSize, ramSize uint8) []byte {
+	rom := newTestHeader("BANKS", 0, cartType, romSize, ramSize)
+	for bank := 1; bank < len(rom)/0x4000; bank++ {
+		rom[bank*0x4000] = uint8(bank)
+	}
+	return rom
+}
+
+func TestMBC1ROMBanking(t *testing.T) {
+	m := newMMU(newBankedROM(0x01, 6, 0)) // 2MB, 128 banks
+
+	steps := []struct {
+		addr      uint16
+		val       uint8
+		low, high uint8 // Bank numbers seen at 0000 and 4000
+	}{
+		{0x2000, 0x00, 0x00, 0x01}, // Bank 0 selects bank 1
+		{0x2000, 0x05, 0x00, 0x05},
+		{0x2000, 0x1F, 0x00, 0x1F},
+		{0x4000, 0x01, 0x00, 0x3F},
+		{0x2000, 0x00, 0x00, 0x21}, // 0x20 can't be selected
+		{0x6000, 0x01, 0x20, 0x21}, // Mode 1 maps 0x20 at 0000-3FFF
+		{0x4000, 0x03, 0x60, 0x61},
+		{0x6000, 0x00, 0x00, 0x61},
+	}
+	for _, s := range steps {
+		m.Write(s.addr, s.val)
+		if low, high := m.Read(0x0000), m.Read(0x4000); low != s.low || high != s.high {
+			t.Errorf("after %02X to %04X: banks %02X/%02X, want %02X/%02X", s.val, s.addr, low, high, s.low, s.high)
+		}
+	}
+}
+
+func TestMBC1SmallROMWraps(t *testing.T) {
+	m := newMMU(newBankedROM(0x01, 2, 0)) // 128KB, 8 banks
+	m.Write(0x2000, 0x0B)
+	if got := m.Read(0x4000); got != 0x03 {
+		t.Errorf("bank 0x0B on an 8-bank ROM read bank %02X, want 03", got)
+	}
+}
+
+func TestMBC1RAM(t *testing.T) {
+	m := newMMU(newBankedROM(0x03, 4, 3)) // 32KB RAM
+
+	m.Write(0xA000, 0x12)
+	if got := m.Read(0xA000); got != 0xFF {
+		t.Errorf("disabled RAM read %02X, want FF", got)
+	}
+
+	m.Write(0x0000, 0x0A)
+	m.Write(0x6000, 0x01)
+	for bank := uint8(0); bank < 4; bank++ {
+		m.Write(0x4000, bank)
+		m.Write(0xA000, 0x10+bank)
+	}
+	for bank := uint8(0); bank < 4; bank++ {
+		m.Write(0x4000, bank)
+		if got := m.Read(0xA000); got != 0x10+bank {
+			t.Errorf("RAM bank %d read %02X", bank, got)
+		}
+	}
+
+	// Mode 0 always uses RAM bank 0
+	m.Write(0x6000, 0x00)
+	if got := m.Read(0xA000); got != 0x10 {
+		t.Errorf("mode 0 read %02X, want bank 0's 10", got)
+	}
+
+	m.Write(0x0000, 0x00)
+	if got := m.Read(0xA000); got != 0xFF {
+		t.Errorf("RAM read %02X after disabling, want FF", got)
+	}
+}
+
+func TestMBC1Multicart(t *testing.T) {
+	rom := newBankedROM(0x01, 5, 0) // 1MB
+	copy(rom[0x104:], nintendoLogo)
+	copy(rom[0x40104:], nintendoLogo)
+	m := newMMU(rom)
+
+	m.Write(0x4000, 0x01)
+	m.Write(0x2000, 0x12) // Bit 4 is ignored
+	if got := m.Read(0x4000); got != 0x12 {
+		t.Errorf("read bank %02X, want 12", got)
+	}
+	m.Write(0x6000, 0x01)
+	if got := m.Read(0x0000); got != 0x10 {
+		t.Errorf("bank 0 window read bank %02X, want 10", got)
+	}
+}
+
+var nintendoLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// MBC2 supports up to 256KB of ROM and has 512 half-byte cells of RAM
+// built in. Both of its registers live in 0000-3FFF, told apart by
+// address bit 8.
+type MBC2 struct {
+	rom []byte
+	ram [512]uint8 // Only the low nibble of each cell exists
+
+	ramEnabled bool
+	romBank    uint8
+}
+
+func newMBC2(rom []byte) *MBC2 {
+	return &MBC2{
+		rom:     rom,
+		romBank: 1,
+	}
+}
+
+func (m *MBC2) ReadROM(addr uint16) uint8 {
+	bank := 0
+	if addr >= 0x4000 {
+		bank = int(m.romBank)
+	}
+	bank %= len(m.rom) / 0x4000
+	return m.rom[bank*0x4000+int(addr&0x3FFF)]
+}
+
+// The RAM repeats through A000-BFFF, and the missing upper nibble reads
+// as ones.
+func (m *MBC2) ReadRAM(addr uint16) uint8 {
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return m.ram[addr&0x1FF] | 0xF0
+}
+
+func (m *MBC2) WriteRAM(addr uint16, val uint8) bool {
+	if !m.ramEnabled {
+		return false
+	}
+	m.ram[addr&0x1FF] = val & 0x0F
+	return true
+}
+
+func (m *MBC2) WriteRegister(addr uint16, val uint8) {
+	if addr >= 0x4000 {
+		return
+	}
+	if addr&0x100 == 0 {
+		m.ramEnabled = val&0x0F == 0x0A
+		return
+	}
+	m.romBank = val & 0x0F
+	if m.romBank == 0 {
+		m.romBank = 1
+	}
+}
+
+type mbc2State struct {
+	RAMEnabled bool
+	ROMBank    uint8
+}
+
+func (m *MBC2) SaveBattery() []byte           { return bytes.Clone(m.ram[:]) }
+func (m *MBC2) LoadBattery(data []byte) error { return loadBatteryRAM(m.ram[:], data) }
+
+func (m *MBC2) SaveState(w io.Writer) error {
+	return writeState(w, m.ram[:], &mbc2State{m.ramEnabled, m.romBank})
+}
+
+func (m *MBC2) LoadState(r io.Reader) error {
+	var s mbc2State
+	if err := readState(r, m.ram[:], &s); err != nil {
+		return err
+	}
+	m.ramEnabled, m.romBank = s.RAMEnabled, s.ROMBank
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestMBC2(t *testing.T) {
+	m := newMMU(newBankedROM(0x06, 3, 0)) // MBC2+BATTERY, 256KB
+
+	m.Write(0x2100, 0x0F)
+	if got := m.Read(0x4000); got != 0x0F {
+		t.Errorf("bank F read %02X", got)
+	}
+	m.Write(0x0100, 0x00) // Bit 8 set selects the ROM bank, even here
+	if got := m.Read(0x4000); got != 0x01 {
+		t.Errorf("bank 0 read %02X, want bank 1", got)
+	}
+
+	m.Write(0x2000, 0x0A) // Bit 8 clear enables RAM
+	m.Write(0xA005, 0x5C)
+	if got := m.Read(0xA005); got != 0xFC {
+		t.Errorf("RAM read %02X, want upper nibble set: FC", got)
+	}
+	if got := m.Read(0xBE05); got != 0xFC {
+		t.Errorf("RAM echo read %02X, want FC", got)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MBC3 supports up to 2MB of ROM, 32KB of RAM and an optional real-time
+// clock whose registers are mapped over the RAM window.
+type MBC3 struct {
+	rom []byte
+	ram []byte
+	rtc *RTC // nil without a TIMER
+
+	ramEnabled bool // Also enables the RTC registers
+	romBank    uint8
+	ramBank    uint8 // 00-03 selects RAM, 08-0C an RTC register
+	latchArmed bool  // Set by writing 0 to 6000-7FFF
+}
+
+func newMBC3(rom []byte, ramSize int, timer bool) *MBC3 {
+	m := &MBC3{
+		rom:     rom,
+		ram:     make([]byte, ramSize),
+		romBank: 1,
+	}
+	if timer {
+		m.rtc = newRTC(time.Now)
+	}
+	return m
+}
+
+func (m *MBC3) ReadROM(addr uint16) uint8 {
+	bank := 0
+	if addr >= 0x4000 {
+		bank = int(m.romBank)
+	}
+	bank %= len(m.rom) / 0x4000
+	return m.rom[bank*0x4000+int(addr&0x3FFF)]
+}
+
+func (m *MBC3) ReadRAM(addr uint16) uint8 {
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	if m.ramBank >= 0x08 {
+		if m.rtc == nil {
+			return 0xFF
+		}
+		return m.rtc.read(m.ramBank)
+	}
+	if len(m.ram) == 0 {
+		return 0xFF
+	}
+	return m.ram[m.ramOffset(addr)]
+}
+
+func (m *MBC3) WriteRAM(addr uint16, val uint8) bool {
+	if !m.ramEnabled {
+		return false
+	}
+	if m.ramBank >= 0x08 {
+		if m.rtc != nil {
+			m.rtc.write(m.ramBank, val)
+		}
+		return false
+	}
+	if len(m.ram) == 0 {
+		return false
+	}
+	m.ram[m.ramOffset(addr)] = val
+	return true
+}
+
+func (m *MBC3) ramOffset(addr uint16) int {
+	return (int(m.ramBank&0x03)*0x2000 + int(addr&0x1FFF)) % len(m.ram)
+}
+
+func (m *MBC3) WriteRegister(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.ramEnabled = val&0x0F == 0x0A
+	case addr < 0x4000:
+		m.romBank = val & 0x7F
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case addr < 0x6000:
+		m.ramBank = val & 0x0F
+	default:
+		// Writing 0 then 1 copies the clock into the readable registers
+		if m.latchArmed && val == 0x01 && m.rtc != nil {
+			m.rtc.latch()
+		}
+		m.latchArmed = val == 0x00
+	}
+}
+
+// SaveBattery appends the RTC footer to the RAM for cartridges with a
+// clock.
+func (m *MBC3) SaveBattery() []byte {
+	data := bytes.Clone(m.ram)
+	if m.rtc != nil {
+		data = append(data, m.rtc.footer()...)
+	}
+	return data
+}
+
+// LoadBattery accepts saves with or without an RTC footer, so saves from
+// emulators that don't write one still load.
+func (m *MBC3) LoadBattery(data []byte) error {
+	if len(data) > len(m.ram) && m.rtc != nil {
+		if err := m.rtc.loadFooter(data[len(m.ram):]); err != nil {
+			return err
+		}
+		data = data[:len(m.ram)]
+	}
+	return loadBatteryRAM(m.ram, data)
+}
+
+type mbc3State struct {
+	RAMEnabled       bool
+	ROMBank, RAMBank uint8
+	LatchArmed       bool
+}
+
+func (m *MBC3) SaveState(w io.Writer) error {
+	err := writeState(w, m.ram, &mbc3State{m.ramEnabled, m.romBank, m.ramBank, m.latchArmed})
+	if err != nil || m.rtc == nil {
+		return err
+	}
+	_, err = w.Write(m.rtc.footer())
+	return err
+}
+
+func (m *MBC3) LoadState(r io.Reader) error {
+	var s mbc3State
+	if err := readState(r, m.ram, &s); err != nil {
+		return err
+	}
+	m.ramEnabled, m.romBank, m.ramBank, m.latchArmed = s.RAMEnabled, s.ROMBank, s.RAMBank, s.LatchArmed
+	if m.rtc == nil {
+		return nil
+	}
+	footer := make([]byte, RTC_FOOTER_SIZE)
+	if _, err := io.ReadFull(r, footer); err != nil {
+		return err
+	}
+	return m.rtc.loadFooter(footer)
+}
+
+// RTC register numbers, as selected through 4000-5FFF
+const (
+	RTC_S  = 0x08
+	RTC_M  = 0x09
+	RTC_H  = 0x0A
+	RTC_DL = 0x0B
+	RTC_DH = 0x0C // Bit 0: day bit 8, bit 6: halt, bit 7: day carry
+)
+
+// RTC is the MBC3 clock. It runs from the host clock, catching up on the
+// elapsed time whenever it is accessed.
+type RTC struct {
+	seconds, minutes, hours uint8
+	days                    uint16 // 9 bits
+	halted, carry           bool
+
+	latched [5]uint8 // Values visible to the game, indexed from RTC_S
+
+	now        func() time.Time
+	lastUpdate time.Time
+}
+
+func newRTC(now func() time.Time) *RTC {
+	return &RTC{
+		now:        now,
+		lastUpdate: now(),
+	}
+}
+
+// update advances the clock by the whole seconds since the last update.
+func (r *RTC) update() {
+	now := r.now()
+	elapsed := int64(now.Sub(r.lastUpdate) / time.Second)
+	if elapsed <= 0 {
+		return
+	}
+	r.lastUpdate = r.lastUpdate.Add(time.Duration(elapsed) * time.Second)
+	if !r.halted {
+		r.advance(elapsed)
+	}
+}
+
+func (r *RTC) advance(seconds int64) {
+	total := int64(r.seconds) + seconds
+	r.seconds = uint8(total % 60)
+	total = int64(r.minutes) + total/60
+	r.minutes = uint8(total % 60)
+	total = int64(r.hours) + total/60
+	r.hours = uint8(total % 24)
+	total = int64(r.days) + total/24
+	if total > 0x1FF {
+		r.carry = true // Sticky until the game clears it
+	}
+	r.days = uint16(total & 0x1FF)
+}
+
+func (r *RTC) registers() [5]uint8 {
+	dh := uint8(r.days >> 8)
+	if r.halted {
+		dh |= 0x40
+	}
+	if r.carry {
+		dh |= 0x80
+	}
+	return [5]uint8{r.seconds, r.minutes, r.hours, uint8(r.days), dh}
+}
+
+func (r *RTC) latch() {
+	r.update()
+	r.latched = r.registers()
+}
+
+func (r *RTC) read(reg uint8) uint8 {
+	if reg > RTC_DH {
+		return 0xFF
+	}
+	return r.latched[reg-RTC_S]
+}
+
+func (r *RTC) write(reg uint8, val uint8) {
+	r.update()
+	switch reg {
+	case RTC_S:
+		r.seconds = val & 0x3F
+		// Writing the seconds also restarts the current second
+		r.lastUpdate = r.now()
+	case RTC_M:
+		r.minutes = val & 0x3F
+	case RTC_H:
+		r.hours = val & 0x1F
+	case RTC_DL:
+		r.days = r.days&0x100 | uint16(val)
+	case RTC_DH:
+		r.days = r.days&0xFF | uint16(val&0x01)<<8
+		r.halted = val&0x40 != 0
+		r.carry = val&0x80 != 0
+	default:
+		return
+	}
+	// Games read back what they wrote without latching again
+	r.latched[reg-RTC_S] = r.registers()[reg-RTC_S]
+}
+
+// The footer most emulators (VBA-M, BGB, mGBA, SameBoy) append to .sav
+// files: the live and latched registers as little-endian 32-bit words,
+// then the Unix time they were saved at as a 64-bit word. Some older
+// emulators write a 32-bit timestamp, giving 44 bytes.
+const (
+	RTC_FOOTER_SIZE       = 48
+	RTC_FOOTER_SIZE_SHORT = 44
+)
+
+func (r *RTC) footer() []byte {
+	r.update()
+	b := make([]byte, RTC_FOOTER_SIZE)
+	regs := r.registers()
+	for i := 0; i < 5; i++ {
+		binary.LittleEndian.PutUint32(b[i*4:], uint32(regs[i]))
+		binary.LittleEndian.PutUint32(b[20+i*4:], uint32(r.latched[i]))
+	}
+	binary.LittleEndian.PutUint64(b[40:], uint64(r.lastUpdate.Unix()))
+	return b
+}
+
+// loadFooter restores the clock and catches up on the time since the
+// footer was saved.
+func (r *RTC) loadFooter(b []byte) error {
+	var saved int64
+	switch len(b) {
+	case RTC_FOOTER_SIZE:
+		saved = int64(binary.LittleEndian.Uint64(b[40:]))
+	case RTC_FOOTER_SIZE_SHORT:
+		saved = int64(binary.LittleEndian.Uint32(b[40:]))
+	default:
+		return fmt.Errorf("RTC footer is %d bytes, expected %d or %d", len(b), RTC_FOOTER_SIZE, RTC_FOOTER_SIZE_SHORT)
+	}
+
+	word := func(i int) uint8 { return uint8(binary.LittleEndian.Uint32(b[i*4:])) }
+	r.seconds, r.minutes, r.hours = word(0)&0x3F, word(1)&0x3F, word(2)&0x1F
+	r.days = uint16(word(4)&0x01)<<8 | uint16(word(3))
+	r.halted = word(4)&0x40 != 0
+	r.carry = word(4)&0x80 != 0
+	for i := range r.latched {
+		r.latched[i] = word(5 + i)
+	}
+
+	r.lastUpdate = time.Unix(saved, 0)
+	r.update()
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a host clock that only moves when told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestMBC3() (*MMU, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	m := newMMU(newBankedROM(0x10, 6, 3)) // MBC3+TIMER+RAM+BATTERY, 2MB
+	m.cart.(*MBC3).rtc = newRTC(clock.now)
+	m.Write(0x0000, 0x0A)
+	return m, clock
+}
+
+func latchRTC(m *MMU) {
+	m.Write(0x6000, 0x00)
+	m.Write(0x6000, 0x01)
+}
+
+func readRTC(m *MMU, reg uint8) uint8 {
+	m.Write(0x4000, reg)
+	return m.Read(0xA000)
+}
+
+func TestMBC3ROMAndRAMBanking(t *testing.T) {
+	m, _ := newTestMBC3()
+	for _, bank := range []uint8{0x01, 0x45, 0x7F} {
+		m.Write(0x2000, bank)
+		if got := m.Read(0x4000); got != bank {
+			t.Errorf("ROM bank %02X read %02X", bank, got)
+		}
+	}
+	m.Write(0x2000, 0x00)
+	if got := m.Read(0x4000); got != 0x01 {
+		t.Errorf("ROM bank 0 read %02X, want 01", got)
+	}
+
+	for bank := uint8(0); bank < 4; bank++ {
+		m.Write(0x4000, bank)
+		m.Write(0xB000, 0xA0+bank)
+	}
+	for bank := uint8(0); bank < 4; bank++ {
+		m.Write(0x4000, bank)
+		if got := m.Read(0xB000); got != 0xA0+bank {
+			t.Errorf("RAM bank %d read %02X", bank, got)
+		}
+	}
+}
+
+func TestMBC3RTCLatch(t *testing.T) {
+	m, clock := newTestMBC3()
+
+	clock.t = clock.t.Add(26*time.Hour + 3*time.Minute + 4*time.Second)
+	if got := readRTC(m, RTC_S); got != 0 {
+		t.Errorf("seconds read %d before latching, want 0", got)
+	}
+
+	latchRTC(m)
+	clock.t = clock.t.Add(time.Minute) // Latched values don't move
+	want := []uint8{4, 3, 2, 1, 0}
+	for i, w := range want {
+		if got := readRTC(m, RTC_S+uint8(i)); got != w {
+			t.Errorf("register %02X read %d, want %d", RTC_S+i, got, w)
+		}
+	}
+}
+
+func TestMBC3RTCHaltAndCarry(t *testing.T) {
+	m, clock := newTestMBC3()
+
+	m.Write(0x4000, RTC_DH)
+	m.Write(0xA000, 0x41) // Halt, day 256
+	clock.t = clock.t.Add(time.Hour)
+	latchRTC(m)
+	if got := readRTC(m, RTC_H); got != 0 {
+		t.Errorf("halted clock advanced to hour %d", got)
+	}
+
+	m.Write(0x4000, RTC_DH)
+	m.Write(0xA000, 0x01)
+	clock.t = clock.t.Add(256 * 24 * time.Hour)
+	latchRTC(m)
+	if got := readRTC(m, RTC_DH); got != 0x80 {
+		t.Errorf("DH read %02X after day overflow, want 80", got)
+	}
+}
+
+func TestRTCFooterRoundTrip(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	rtc := newRTC(clock.now)
+	rtc.advance(3*86400 + 5*3600 + 6*60 + 7)
+	rtc.latch()
+
+	footer := rtc.footer()
+	if len(footer) != RTC_FOOTER_SIZE {
+		t.Fatalf("footer is %d bytes", len(footer))
+	}
+
+	// Loading an hour later catches up on the time the game was off
+	clock.t = clock.t.Add(time.Hour)
+	loaded := newRTC(clock.now)
+	if err := loaded.loadFooter(footer); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.latched != rtc.latched {
+		t.Errorf("latched %v, want %v", loaded.latched, rtc.latched)
+	}
+	if got := loaded.registers(); got != [5]uint8{7, 6, 6, 3, 0} {
+		t.Errorf("registers %v after an hour", got)
+	}
+
+	if err := loaded.loadFooter(footer[:40]); err == nil {
+		t.Error("expected an error for a truncated footer")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// MBC5 supports up to 8MB of ROM with a 9-bit bank number, which unlike
+// the older controllers can select bank 0, and up to 128KB of RAM.
+type MBC5 struct {
+	rom []byte
+	ram []byte
+
+	ramEnabled bool
+	romBank    uint16
+	ramBank    uint8
+
+	// Rumble cartridges wire bit 3 of the RAM bank register to the motor
+	// instead of RAM. OnRumble is called whenever the motor turns on or
+	// off.
+	rumble   bool
+	motorOn  bool
+	OnRumble func(on bool)
+}
+
+func newMBC5(rom []byte, ramSize int, rumble bool) *MBC5 {
+	return &MBC5{
+		rom:     rom,
+		ram:     make([]byte, ramSize),
+		romBank: 1,
+		rumble:  rumble,
+	}
+}
+
+func (m *MBC5) ReadROM(addr uint16) uint8 {
+	bank := 0
+	if addr >= 0x4000 {
+		bank = int(m.romBank)
+	}
+	bank %= len(m.rom) / 0x4000
+	return m.rom[bank*0x4000+int(addr&0x3FFF)]
+}
+
+func (m *MBC5) ramOffset(addr uint16) int {
+	return (int(m.ramBank)*0x2000 + int(addr&0x1FFF)) % len(m.ram)
+}
+
+func (m *MBC5) ReadRAM(addr uint16) uint8 {
+	if !m.ramEnabled || len(m.ram) == 0 {
+		return 0xFF
+	}
+	return m.ram[m.ramOffset(addr)]
+}
+
+func (m *MBC5) WriteRAM(addr uint16, val uint8) bool {
+	if !m.ramEnabled || len(m.ram) == 0 {
+		return false
+	}
+	m.ram[m.ramOffset(addr)] = val
+	return true
+}
+
+func (m *MBC5) WriteRegister(addr uint16, val uint8) {
+	switch {
+	case addr < 0x2000:
+		m.ramEnabled = val == 0x0A // MBC5 checks all eight bits
+	case addr < 0x3000:
+		m.romBank = m.romBank&0x100 | uint16(val)
+	case addr < 0x4000:
+		m.romBank = m.romBank&0xFF | uint16(val&0x01)<<8
+	case addr < 0x6000:
+		m.ramBank = val & 0x0F
+		if m.rumble {
+			m.ramBank &= 0x07
+			m.setMotor(val&0x08 != 0)
+		}
+	}
+}
+
+func (m *MBC5) setMotor(on bool) {
+	if on == m.motorOn {
+		return
+	}
+	m.motorOn = on
+	if m.OnRumble != nil {
+		m.OnRumble(on)
+	}
+}
+
+type mbc5State struct {
+	RAMEnabled bool
+	ROMBank    uint16
+	RAMBank    uint8
+	MotorOn    bool
+}
+
+func (m *MBC5) SaveBattery() []byte           { return bytes.Clone(m.ram) }
+func (m *MBC5) LoadBattery(data []byte) error { return loadBatteryRAM(m.ram, data) }
+
+func (m *MBC5) SaveState(w io.Writer) error {
+	return writeState(w, m.ram, &mbc5State{m.ramEnabled, m.romBank, m.ramBank, m.motorOn})
+}
+
+func (m *MBC5) LoadState(r io.Reader) error {
+	var s mbc5State
+	if err := readState(r, m.ram, &s); err != nil {
+		return err
+	}
+	m.ramEnabled, m.romBank, m.ramBank = s.RAMEnabled, s.ROMBank, s.RAMBank
+	m.setMotor(s.MotorOn)
+	return nil
+}
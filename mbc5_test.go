@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestMBC5ROMBanking(t *testing.T) {
+	m := newMMU(newBankedROM(0x19, 8, 0)) // 8MB, 512 banks
+
+	m.Write(0x2000, 0x00)
+	if got := m.Read(0x4000); got != 0x00 {
+		t.Errorf("bank 0 read %02X, MBC5 should map bank 0", got)
+	}
+
+	m.Write(0x2000, 0x9A)
+	if got := m.Read(0x4000); got != 0x9A {
+		t.Errorf("bank 9A read %02X", got)
+	}
+}
+
+func TestMBC5HighBank(t *testing.T) {
+	rom := newBankedROM(0x19, 8, 0)
+	rom[0x134*0x4000+1] = 0xAB
+	m := newMMU(rom)
+	m.Write(0x2000, 0x34)
+	m.Write(0x3000, 0x01)
+	if got := m.Read(0x4001); got != 0xAB {
+		t.Errorf("bank 134 read %02X, want AB", got)
+	}
+}
+
+func TestMBC5RAMAndRumble(t *testing.T) {
+	m := newMMU(newBankedROM(0x1E, 1, 4)) // MBC5+RUMBLE+RAM+BATTERY, 128KB RAM
+	mbc := m.cart.(*MBC5)
+	var events []bool
+	mbc.OnRumble = func(on bool) { events = append(events, on) }
+
+	m.Write(0x0000, 0x0A)
+	m.Write(0x4000, 0x0B) // RAM bank 3, motor on
+	m.Write(0xA000, 0x33)
+	m.Write(0x4000, 0x03) // Same bank, motor off
+	if got := m.Read(0xA000); got != 0x33 {
+		t.Errorf("RAM bank 3 read %02X", got)
+	}
+	m.Write(0x4000, 0x03)
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Errorf("rumble events %v, want [true false]", events)
+	}
+
+	m.Write(0x0000, 0x1A) // Only exactly 0x0A enables RAM
+	if got := m.Read(0xA000); got != 0xFF {
+		t.Errorf("RAM read %02X after writing 1A, want FF", got)
+	}
+}
@@ -1,47 +1,117 @@
 package main
 
+import "fmt"
+
 type MMU struct {
-    rom  []byte     // Load the file into here
     vram [8192]byte // 8KB
-	eram [8192]byte // 8KB
     wram [8192]byte // 8KB
     oam  [160]byte  // Sprite memory
     io   [128]byte  // I/O ports
     hram [127]byte  // High RAM
     ie   byte       // Interrupt Enable (just 1 byte)
-	scanlineCounter int // Track cycles for LY register
+	cart Cartridge  // ROM and external RAM
+	bootROM []byte  // Mapped over 0000-00FF until FF50 is written
+	timer   Timer   // FF04-FF07
+	joypad  Joypad  // FF00
+	ramWrites int   // Writes that reached external RAM, so battery saves know when to flush
+	ppu     *PPU    // Stepped as the CPU ticks, and owns LY and STAT
 }
 
+// newMMU starts with the I/O registers as the DMG boot ROM leaves them.
 func newMMU(rom []byte) *MMU {
-	return &MMU{
-		rom: rom,
+	m := &MMU{
+		cart: newCartridge(rom),
+	}
+	for addr, val := range postBootIO {
+		m.io[addr-0xFF00] = val
 	}
+	m.timer.counter = 0xABCC // DIV reads AB
+	m.joypad.selects = 0x30  // P1 reads CF
+	m.ppu = newPPU(m)
+	return m
 }
 
-func (m *MMU) UpdateScanline(cycles int) {
-	m.scanlineCounter += cycles
-	if m.scanlineCounter >= 456 { // 456 cycles per scanline
-		m.scanlineCounter -= 456
-		currentLY := m.io[0x44] // LY is at offset 0x44
-		currentLY++
-		if currentLY == 144 {
-			m.io[0x0F] |= 0x01 // Set VBLANK interrupt flag
-		}
-		if currentLY > 153 {
-			currentLY = 0
+// I/O register values after the DMG boot ROM hands over to the cartridge
+var postBootIO = map[uint16]byte{
+	0xFF02: 0x7E, // SC
+	0xFF0F: 0xE1, // IF
+	0xFF10: 0x80, // NR10
+	0xFF11: 0xBF, // NR11
+	0xFF12: 0xF3, // NR12
+	0xFF13: 0xFF, // NR13
+	0xFF14: 0xBF, // NR14
+	0xFF16: 0x3F, // NR21
+	0xFF18: 0xFF, // NR23
+	0xFF19: 0xBF, // NR24
+	0xFF1A: 0x7F, // NR30
+	0xFF1B: 0xFF, // NR31
+	0xFF1C: 0x9F, // NR32
+	0xFF1D: 0xFF, // NR33
+	0xFF1E: 0xBF, // NR34
+	0xFF20: 0xFF, // NR41
+	0xFF23: 0xBF, // NR44
+	0xFF24: 0x77, // NR50
+	0xFF25: 0xF3, // NR51
+	0xFF26: 0xF1, // NR52
+	0xFF40: 0x91, // LCDC
+	0xFF41: 0x85, // STAT
+	0xFF46: 0xFF, // DMA
+	0xFF47: 0xFC, // BGP
+}
+
+// mapBootROM maps a 256-byte DMG boot ROM over the start of the cartridge
+// and clears the I/O registers back to their power-on state.
+func (m *MMU) mapBootROM(boot []byte) error {
+	if len(boot) != 0x100 {
+		return fmt.Errorf("boot ROM is %d bytes, expected 256", len(boot))
+	}
+	m.bootROM = boot
+	m.io = [128]byte{}
+	m.timer = Timer{}
+	m.joypad.selects = 0
+	return nil
+}
+
+// Tick advances everything clocked alongside the CPU by the given number
+// of T-cycles. The CPU calls it once per M-cycle as each bus access
+// happens.
+func (m *MMU) Tick(cycles int) {
+	for i := 0; i < cycles; i += 4 {
+		if m.timer.step() {
+			m.io[0x0F] |= TIMER_INTERRUPT
 		}
-		m.io[0x44] = currentLY
+	}
+	m.ppu.step(cycles)
+}
+
+// SetButton updates an input, requesting the joypad interrupt if that
+// pulls a selected P1 line low. The interrupt also wakes the CPU from
+// STOP.
+func (m *MMU) SetButton(b Button, pressed bool) {
+	if m.joypad.setButton(b, pressed) {
+		m.io[0x0F] |= JOYPAD_INTERRUPT
+	}
+}
+
+// dma copies 160 bytes from page XX00 into OAM. The hardware takes 160
+// M-cycles to do this while the CPU waits in HRAM; here it happens at once.
+func (m *MMU) dma(page uint8) {
+	src := uint16(page) << 8
+	for i := range m.oam {
+		m.oam[i] = m.Read(src + uint16(i))
 	}
 }
 
 func (m *MMU) Read(addr uint16) byte {
 	switch {
+	case addr < 0x100 && m.bootROM != nil:
+		return m.bootROM[addr]
 	case addr < 0x8000: // ROM
-		return m.rom[addr]
+		return m.cart.ReadROM(addr)
 	case addr < 0xA000: // VRAM
 		return m.vram[addr - 0x8000]
 	case addr < 0xC000:	// ERAM
-        return 0
+		return m.cart.ReadRAM(addr)
 	case addr < 0xE000: //WRAM
 		return m.wram[addr - 0xC000]
 	case addr < 0xFE00: // Echo ram
@@ -55,6 +125,15 @@ func (m *MMU) Read(addr uint16) byte {
 			// Return the actual LY value from io array
 			return m.io[0x44]
 		}
+		if addr == 0xFF00 {
+			return m.joypad.read()
+		}
+		if addr == 0xFF41 {
+			return m.io[0x41] | 0x80 // Unused bit
+		}
+		if addr >= 0xFF04 && addr <= 0xFF07 {
+			return m.timer.read(addr)
+		}
 		return m.io[addr - 0xFF00]
 	case addr < 0xFFFF: // HRAM
 		return m.hram[addr - 0xFF80]
@@ -68,12 +147,14 @@ func (m *MMU) Read(addr uint16) byte {
 
 func (m *MMU) Write(addr uint16, b byte) {
 	switch {
-	case addr < 0x8000:
-		return
+	case addr < 0x8000: // MBC registers
+		m.cart.WriteRegister(addr, b)
 	case addr < 0xA000: // VRAM
 		m.vram[addr - 0x8000] = b
 	case addr < 0xC000:	// ERAM
-		m.eram[addr - 0xA000] = b
+		if m.cart.WriteRAM(addr, b) {
+			m.ramWrites++
+		}
 	case addr < 0xE000: //WRAM
 		m.wram[addr - 0xC000] = b
 	case addr < 0xFE00: // Echo ram
@@ -86,6 +167,28 @@ func (m *MMU) Write(addr uint16, b byte) {
 		if addr == 0xFF44 { // LY (scanline)
 			return // LY is read-only, writes are ignored
 		}
+		if addr == 0xFF41 { // STAT: only the interrupt enables are writable
+			m.io[0x41] = b&0x78 | m.io[0x41]&0x07
+			return
+		}
+		if addr == 0xFF46 { // OAM DMA
+			m.io[0x46] = b
+			m.dma(b)
+			return
+		}
+		if addr == 0xFF50 && b != 0 { // Boot ROM finished
+			m.bootROM = nil
+		}
+		if addr == 0xFF00 {
+			if m.joypad.write(b) {
+				m.io[0x0F] |= JOYPAD_INTERRUPT
+			}
+			return
+		}
+		if addr >= 0xFF04 && addr <= 0xFF07 {
+			m.timer.write(addr, b)
+			return
+		}
 		m.io[addr - 0xFF00] = b
 	case addr < 0xFFFF: // HRAM
 		m.hram[addr - 0xFF80] = b
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestPostBootState(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	cpu := newCPU(m)
+
+	r := cpu.Reg
+	if r.A != 0x01 || r.F != 0xB0 || r.GetBC() != 0x0013 || r.GetDE() != 0x00D8 || r.GetHL() != 0x014D {
+		t.Errorf("registers %+v", *r)
+	}
+	if r.SP != 0xFFFE || r.PC != 0x0100 {
+		t.Errorf("SP=%04X PC=%04X", r.SP, r.PC)
+	}
+	if lcdc, bgp := m.Read(0xFF40), m.Read(0xFF47); lcdc != 0x91 || bgp != 0xFC {
+		t.Errorf("LCDC=%02X BGP=%02X", lcdc, bgp)
+	}
+}
+
+func TestBootROMHandover(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	rom[0x0000] = 0xAA
+	rom[0x0100] = 0x00 // NOP at the cartridge entry point
+
+	// A boot ROM that just unmaps itself, ending at 00FF like the real one
+	boot := make([]byte, 0x100)
+	boot[0x00] = 0xC3 // JP $00FC
+	boot[0x01], boot[0x02] = 0xFC, 0x00
+	copy(boot[0xFC:], []byte{0x3E, 0x01, 0xE0, 0x50}) // LD A, 1; LDH [rBANK], A
+
+	m := newMMU(rom)
+	if err := m.mapBootROM(boot); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Read(0xFF40); got != 0x00 {
+		t.Errorf("LCDC=%02X at power-on, want 00", got)
+	}
+
+	cpu := newCPU(m)
+	*cpu.Reg = Registers{}
+	if got := m.Read(0x0000); got != 0xC3 {
+		t.Fatalf("read %02X at 0000, want the boot ROM", got)
+	}
+	for i := 0; i < 3; i++ {
+		cpu.Step()
+	}
+	if cpu.Reg.PC != 0x0100 {
+		t.Errorf("PC=%04X after the boot ROM, want 0100", cpu.Reg.PC)
+	}
+	if got := m.Read(0x0000); got != 0xAA {
+		t.Errorf("read %02X at 0000 after FF50, want the cartridge", got)
+	}
+
+	if err := m.mapBootROM(make([]byte, 0x200)); err == nil {
+		t.Error("expected an error for a 512-byte boot ROM")
+	}
+}
+
+func TestOAMDMA(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	for i := 0; i < 160; i++ {
+		m.Write(0xC100+uint16(i), uint8(i+1))
+	}
+	m.Write(0xFF46, 0xC1)
+	if m.oam[0] != 1 || m.oam[159] != 160 || m.Read(0xFF46) != 0xC1 {
+		t.Errorf("OAM starts %02X and ends %02X, DMA reads %02X", m.oam[0], m.oam[159], m.Read(0xFF46))
+	}
+}
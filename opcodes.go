@@ -0,0 +1,633 @@
+package main
+
+import "fmt"
+
+// opcode describes one SM83 instruction. The same table drives cycle
+// accounting and disassembly; the code that runs it is in execs.
+type opcode struct {
+	// mnemonic is written in RGBDS syntax. Immediate operands appear as
+	// n8/n16 (data), a8/a16 (addresses, a8 being an FF00 page offset)
+	// and e8 (signed relative offset).
+	mnemonic string
+	length   uint8
+	// cycles is the T-cycle cost, or the cost of a conditional branch
+	// that is not taken. branchCycles is the cost when it is taken, and
+	// zero for instructions without a condition.
+	cycles       uint8
+	branchCycles uint8
+	// flags lists the effect on Z, N, H and C in that order: the flag
+	// letter if computed, '0' or '1' if forced, '-' if untouched.
+	flags string
+}
+
+var (
+	opcodes   [256]opcode
+	cbOpcodes [256]opcode
+
+	// execs and cbExecs run each instruction. They are kept apart from
+	// the metadata so dispatch only loads from a dense table of funcs.
+	// The CB prefix has no entry; Step looks up the second byte in
+	// cbExecs instead.
+	execs   [256]func(cpu *CPU)
+	cbExecs [256]func(cpu *CPU)
+)
+
+var (
+	reg8Names = [8]string{"B", "C", "D", "E", "H", "L", "[HL]", "A"}
+	rpNames   = [4]string{"BC", "DE", "HL", "SP"}
+	rp2Names  = [4]string{"BC", "DE", "HL", "AF"}
+	condNames = [4]string{"NZ", "Z", "NC", "C"}
+)
+
+var aluOps = [8]struct {
+	mnemonic string
+	flags    string
+}{
+	{"ADD A,", "Z0HC"},
+	{"ADC A,", "Z0HC"},
+	{"SUB A,", "Z1HC"},
+	{"SBC A,", "Z1HC"},
+	{"AND A,", "Z010"},
+	{"XOR A,", "Z000"},
+	{"OR A,", "Z000"},
+	{"CP A,", "Z1HC"},
+}
+
+var shiftNames = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+
+func init() {
+	for i := 0; i < 256; i++ {
+		opcodes[i], execs[i] = decodeOpcode(uint8(i))
+		cbOpcodes[i], cbExecs[i] = decodeCBOpcode(uint8(i))
+	}
+}
+
+// The decoders below return an exec built from a func literal specific
+// to the operation, with only data such as a register index captured.
+// Go compiles each literal once, so a branch or call inside it that
+// depended on the operand fields would be shared, and mispredicted, by
+// every opcode built from it.
+
+// decodeOpcode builds the table entry for an unprefixed opcode from its
+// bit fields: x (bits 7-6), y (bits 5-3), z (bits 2-0), and y split
+// further into p (bits 5-4) and q (bit 3).
+func decodeOpcode(code uint8) (opcode, func(cpu *CPU)) {
+	x := code >> 6
+	y := (code >> 3) & 0x07
+	z := code & 0x07
+	p := y >> 1
+	q := y & 0x01
+
+	switch x {
+	case 0:
+		return decodeBlock0(y, z, p, q)
+	case 1:
+		if y == 6 && z == 6 {
+			return opcode{"HALT", 1, 4, 0, "----"}, (*CPU).halt
+		}
+		cycles := uint8(4)
+		if y == 6 || z == 6 {
+			cycles = 8
+		}
+		return opcode{"LD " + reg8Names[y] + ", " + reg8Names[z], 1, cycles, 0, "----"}, loadExec(y, z)
+	case 2:
+		alu := aluOps[y]
+		cycles := uint8(4)
+		if z == 6 {
+			cycles = 8
+		}
+		return opcode{alu.mnemonic + " " + reg8Names[z], 1, cycles, 0, alu.flags}, aluExec(y, z)
+	default:
+		return decodeBlock3(code, y, z, p, q)
+	}
+}
+
+func decodeBlock0(y, z, p, q uint8) (opcode, func(cpu *CPU)) {
+	switch z {
+	case 0:
+		switch y {
+		case 0:
+			return opcode{"NOP", 1, 4, 0, "----"}, func(cpu *CPU) {}
+		case 1:
+			return opcode{"LD [a16], SP", 3, 20, 0, "----"}, func(cpu *CPU) {
+				addr := cpu.fetch16()
+				cpu.write(addr, uint8(cpu.Reg.SP&0xFF))
+				cpu.write(addr+1, uint8(cpu.Reg.SP>>8))
+			}
+		case 2:
+			return opcode{"STOP", 2, 4, 0, "----"}, (*CPU).stop
+		case 3:
+			return opcode{"JR e8", 2, 12, 0, "----"}, func(cpu *CPU) {
+				cpu.jr(true)
+			}
+		default:
+			mask, want := condFlags(y - 4)
+			return opcode{"JR " + condNames[y-4] + ", e8", 2, 8, 12, "----"}, func(cpu *CPU) {
+				cpu.jr(cpu.Reg.F&mask == want)
+			}
+		}
+	case 1:
+		if q == 0 {
+			op := opcode{"LD " + rpNames[p] + ", n16", 3, 12, 0, "----"}
+			if p == 3 {
+				return op, func(cpu *CPU) {
+					cpu.Reg.SP = cpu.fetch16()
+				}
+			}
+			return op, func(cpu *CPU) {
+				cpu.setPair(p, cpu.fetch16())
+			}
+		}
+		op := opcode{"ADD HL, " + rpNames[p], 1, 8, 0, "-0HC"}
+		if p == 3 {
+			return op, func(cpu *CPU) {
+				cpu.idle()
+				cpu.add16(cpu.Reg.SP)
+			}
+		}
+		return op, func(cpu *CPU) {
+			cpu.idle()
+			cpu.add16(cpu.pair(p))
+		}
+	case 2:
+		return decodeIndirectLoad(p, q)
+	case 3:
+		// INC and DEC share a literal, adding 1 or -1
+		op := opcode{"INC " + rpNames[p], 1, 8, 0, "----"}
+		delta := uint16(1)
+		if q == 1 {
+			op.mnemonic = "DEC " + rpNames[p]
+			delta = 0xFFFF
+		}
+		if p == 3 {
+			return op, func(cpu *CPU) {
+				cpu.idle()
+				cpu.Reg.SP += delta
+			}
+		}
+		return op, func(cpu *CPU) {
+			cpu.idle()
+			cpu.setPair(p, cpu.pair(p)+delta)
+		}
+	case 4:
+		if y == 6 {
+			return opcode{"INC [HL]", 1, 12, 0, "Z0H-"}, func(cpu *CPU) {
+				cpu.writeHL(cpu.inc(cpu.readHL()))
+			}
+		}
+		return opcode{"INC " + reg8Names[y], 1, 4, 0, "Z0H-"}, func(cpu *CPU) {
+			*cpu.regs[y] = cpu.inc(*cpu.regs[y])
+		}
+	case 5:
+		if y == 6 {
+			return opcode{"DEC [HL]", 1, 12, 0, "Z1H-"}, func(cpu *CPU) {
+				cpu.writeHL(cpu.dec(cpu.readHL()))
+			}
+		}
+		return opcode{"DEC " + reg8Names[y], 1, 4, 0, "Z1H-"}, func(cpu *CPU) {
+			*cpu.regs[y] = cpu.dec(*cpu.regs[y])
+		}
+	case 6:
+		if y == 6 {
+			return opcode{"LD [HL], n8", 2, 12, 0, "----"}, func(cpu *CPU) {
+				cpu.writeHL(cpu.fetch8())
+			}
+		}
+		return opcode{"LD " + reg8Names[y] + ", n8", 2, 8, 0, "----"}, func(cpu *CPU) {
+			*cpu.regs[y] = cpu.fetch8()
+		}
+	default:
+		return decodeAccumulatorOp(y)
+	}
+}
+
+// decodeIndirectLoad covers the loads between A and memory through BC,
+// DE, and HL with post-increment/decrement.
+func decodeIndirectLoad(p, q uint8) (opcode, func(cpu *CPU)) {
+	pointers := [4]string{"[BC]", "[DE]", "[HL+]", "[HL-]"}
+	store := opcode{"LD " + pointers[p] + ", A", 1, 8, 0, "----"}
+	load := opcode{"LD A, " + pointers[p], 1, 8, 0, "----"}
+
+	if p < 2 {
+		if q == 0 {
+			return store, func(cpu *CPU) {
+				cpu.write(cpu.pair(p), cpu.Reg.A)
+			}
+		}
+		return load, func(cpu *CPU) {
+			cpu.Reg.A = cpu.read(cpu.pair(p))
+		}
+	}
+
+	delta := uint16(1)
+	if p == 3 {
+		delta = 0xFFFF
+	}
+	if q == 0 {
+		return store, func(cpu *CPU) {
+			cpu.write(cpu.postHL(delta), cpu.Reg.A)
+		}
+	}
+	return load, func(cpu *CPU) {
+		cpu.Reg.A = cpu.read(cpu.postHL(delta))
+	}
+}
+
+// decodeAccumulatorOp covers the rotates and flag operations in column 7
+// of the first block.
+func decodeAccumulatorOp(y uint8) (opcode, func(cpu *CPU)) {
+	switch y {
+	case 0:
+		return opcode{"RLCA", 1, 4, 0, "000C"}, func(cpu *CPU) {
+			cpu.Reg.A = cpu.rlc(cpu.Reg.A)
+			cpu.Reg.SetZero(false)
+		}
+	case 1:
+		return opcode{"RRCA", 1, 4, 0, "000C"}, func(cpu *CPU) {
+			cpu.Reg.A = cpu.rrc(cpu.Reg.A)
+			cpu.Reg.SetZero(false)
+		}
+	case 2:
+		return opcode{"RLA", 1, 4, 0, "000C"}, func(cpu *CPU) {
+			cpu.Reg.A = cpu.rl(cpu.Reg.A)
+			cpu.Reg.SetZero(false)
+		}
+	case 3:
+		return opcode{"RRA", 1, 4, 0, "000C"}, func(cpu *CPU) {
+			cpu.Reg.A = cpu.rr(cpu.Reg.A)
+			cpu.Reg.SetZero(false)
+		}
+	case 4:
+		return opcode{"DAA", 1, 4, 0, "Z-0C"}, (*CPU).daa
+	case 5:
+		return opcode{"CPL", 1, 4, 0, "-11-"}, func(cpu *CPU) {
+			cpu.Reg.A = ^cpu.Reg.A
+			cpu.Reg.SetSubtract(true)
+			cpu.Reg.SetHalfCarry(true)
+		}
+	case 6:
+		return opcode{"SCF", 1, 4, 0, "-001"}, func(cpu *CPU) {
+			cpu.Reg.SetSubtract(false)
+			cpu.Reg.SetHalfCarry(false)
+			cpu.Reg.SetCarry(true)
+		}
+	default:
+		return opcode{"CCF", 1, 4, 0, "-00C"}, func(cpu *CPU) {
+			cpu.Reg.SetSubtract(false)
+			cpu.Reg.SetHalfCarry(false)
+			cpu.Reg.SetCarry(!cpu.Reg.GetCarry())
+		}
+	}
+}
+
+func decodeBlock3(code, y, z, p, q uint8) (opcode, func(cpu *CPU)) {
+	illegal := opcode{"ILLEGAL", 1, 4, 0, "----"}
+	illegalExec := func(cpu *CPU) {
+		cpu.illegalOpcode(code)
+	}
+
+	switch z {
+	case 0:
+		switch y {
+		case 4:
+			return opcode{"LDH [a8], A", 2, 12, 0, "----"}, func(cpu *CPU) {
+				cpu.write(0xFF00|uint16(cpu.fetch8()), cpu.Reg.A)
+			}
+		case 5:
+			return opcode{"ADD SP, e8", 2, 16, 0, "00HC"}, func(cpu *CPU) {
+				offset := int8(cpu.fetch8())
+				cpu.idle()
+				cpu.idle()
+				cpu.Reg.SP = cpu.addSP(offset)
+			}
+		case 6:
+			return opcode{"LDH A, [a8]", 2, 12, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.A = cpu.read(0xFF00 | uint16(cpu.fetch8()))
+			}
+		case 7:
+			return opcode{"LD HL, SP+e8", 2, 12, 0, "00HC"}, func(cpu *CPU) {
+				offset := int8(cpu.fetch8())
+				cpu.idle()
+				cpu.Reg.SetHL(cpu.addSP(offset))
+			}
+		default:
+			mask, want := condFlags(y)
+			return opcode{"RET " + condNames[y], 1, 8, 20, "----"}, func(cpu *CPU) {
+				// The condition is checked during an internal cycle
+				cpu.idle()
+				cpu.branched = cpu.Reg.F&mask == want
+				if cpu.branched {
+					cpu.Reg.PC = cpu.pop()
+					cpu.idle()
+				}
+			}
+		}
+	case 1:
+		if q == 0 {
+			if p == 3 {
+				return opcode{"POP AF", 1, 12, 0, "ZNHC"}, func(cpu *CPU) {
+					cpu.Reg.SetAF(cpu.pop())
+				}
+			}
+			return opcode{"POP " + rp2Names[p], 1, 12, 0, "----"}, func(cpu *CPU) {
+				cpu.setPair(p, cpu.pop())
+			}
+		}
+		switch p {
+		case 0:
+			return opcode{"RET", 1, 16, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.PC = cpu.pop()
+				cpu.idle()
+			}
+		case 1:
+			return opcode{"RETI", 1, 16, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.PC = cpu.pop()
+				cpu.idle()
+				cpu.Reg.IME = true
+			}
+		case 2:
+			return opcode{"JP HL", 1, 4, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.PC = cpu.Reg.GetHL()
+			}
+		default:
+			return opcode{"LD SP, HL", 1, 8, 0, "----"}, func(cpu *CPU) {
+				cpu.idle()
+				cpu.Reg.SP = cpu.Reg.GetHL()
+			}
+		}
+	case 2:
+		switch y {
+		case 4:
+			return opcode{"LDH [C], A", 1, 8, 0, "----"}, func(cpu *CPU) {
+				cpu.write(0xFF00|uint16(cpu.Reg.C), cpu.Reg.A)
+			}
+		case 5:
+			return opcode{"LD [a16], A", 3, 16, 0, "----"}, func(cpu *CPU) {
+				cpu.write(cpu.fetch16(), cpu.Reg.A)
+			}
+		case 6:
+			return opcode{"LDH A, [C]", 1, 8, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.A = cpu.read(0xFF00 | uint16(cpu.Reg.C))
+			}
+		case 7:
+			return opcode{"LD A, [a16]", 3, 16, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.A = cpu.read(cpu.fetch16())
+			}
+		default:
+			mask, want := condFlags(y)
+			return opcode{"JP " + condNames[y] + ", a16", 3, 12, 16, "----"}, func(cpu *CPU) {
+				cpu.jp(cpu.Reg.F&mask == want)
+			}
+		}
+	case 3:
+		switch y {
+		case 0:
+			return opcode{"JP a16", 3, 16, 0, "----"}, func(cpu *CPU) {
+				cpu.jp(true)
+			}
+		case 1:
+			// Step looks up the second byte in cbOpcodes instead
+			return opcode{"PREFIX", 1, 4, 0, "----"}, nil
+		case 6:
+			return opcode{"DI", 1, 4, 0, "----"}, func(cpu *CPU) {
+				cpu.Reg.IME = false
+			}
+		case 7:
+			return opcode{"EI", 1, 4, 0, "----"}, func(cpu *CPU) {
+				cpu.imeScheduled = true
+			}
+		default:
+			return illegal, illegalExec
+		}
+	case 4:
+		if y >= 4 {
+			return illegal, illegalExec
+		}
+		mask, want := condFlags(y)
+		return opcode{"CALL " + condNames[y] + ", a16", 3, 12, 24, "----"}, func(cpu *CPU) {
+			cpu.call(cpu.Reg.F&mask == want)
+		}
+	case 5:
+		if q == 0 {
+			if p == 3 {
+				return opcode{"PUSH AF", 1, 16, 0, "----"}, func(cpu *CPU) {
+					cpu.push(cpu.Reg.GetAF())
+				}
+			}
+			return opcode{"PUSH " + rp2Names[p], 1, 16, 0, "----"}, func(cpu *CPU) {
+				cpu.push(cpu.pair(p))
+			}
+		}
+		if p != 0 {
+			return illegal, illegalExec
+		}
+		return opcode{"CALL a16", 3, 24, 0, "----"}, func(cpu *CPU) {
+			cpu.call(true)
+		}
+	case 6:
+		alu := aluOps[y]
+		return opcode{alu.mnemonic + " n8", 2, 8, 0, alu.flags}, aluImmediateExec(y)
+	default:
+		vector := uint16(y) * 8
+		return opcode{fmt.Sprintf("RST $%02X", vector), 1, 16, 0, "----"}, func(cpu *CPU) {
+			cpu.push(cpu.Reg.PC)
+			cpu.Reg.PC = vector
+		}
+	}
+}
+
+// condFlags returns the F bits a branch condition tests, and the value
+// they must have for it to hold, in the order NZ, Z, NC, C.
+func condFlags(cc uint8) (mask, want uint8) {
+	switch cc {
+	case 0:
+		return ZERO_FLAG, 0
+	case 1:
+		return ZERO_FLAG, ZERO_FLAG
+	case 2:
+		return CARRY_FLAG, 0
+	default:
+		return CARRY_FLAG, CARRY_FLAG
+	}
+}
+
+// The exec builders below split out the (HL) forms, which touch memory,
+// so the register forms only index CPU.regs.
+
+func loadExec(dst, src uint8) func(cpu *CPU) {
+	switch {
+	case dst == 6:
+		return func(cpu *CPU) {
+			cpu.writeHL(*cpu.regs[src])
+		}
+	case src == 6:
+		return func(cpu *CPU) {
+			*cpu.regs[dst] = cpu.readHL()
+		}
+	default:
+		return func(cpu *CPU) {
+			*cpu.regs[dst] = *cpu.regs[src]
+		}
+	}
+}
+
+func aluExec(op, src uint8) func(cpu *CPU) {
+	if src == 6 {
+		switch op {
+		case 0:
+			return func(cpu *CPU) { cpu.add(cpu.readHL()) }
+		case 1:
+			return func(cpu *CPU) { cpu.adc(cpu.readHL()) }
+		case 2:
+			return func(cpu *CPU) { cpu.sub(cpu.readHL()) }
+		case 3:
+			return func(cpu *CPU) { cpu.sbc(cpu.readHL()) }
+		case 4:
+			return func(cpu *CPU) { cpu.and(cpu.readHL()) }
+		case 5:
+			return func(cpu *CPU) { cpu.xor(cpu.readHL()) }
+		case 6:
+			return func(cpu *CPU) { cpu.or(cpu.readHL()) }
+		default:
+			return func(cpu *CPU) { cpu.cp(cpu.readHL()) }
+		}
+	}
+
+	switch op {
+	case 0:
+		return func(cpu *CPU) { cpu.add(*cpu.regs[src]) }
+	case 1:
+		return func(cpu *CPU) { cpu.adc(*cpu.regs[src]) }
+	case 2:
+		return func(cpu *CPU) { cpu.sub(*cpu.regs[src]) }
+	case 3:
+		return func(cpu *CPU) { cpu.sbc(*cpu.regs[src]) }
+	case 4:
+		return func(cpu *CPU) { cpu.and(*cpu.regs[src]) }
+	case 5:
+		return func(cpu *CPU) { cpu.xor(*cpu.regs[src]) }
+	case 6:
+		return func(cpu *CPU) { cpu.or(*cpu.regs[src]) }
+	default:
+		return func(cpu *CPU) { cpu.cp(*cpu.regs[src]) }
+	}
+}
+
+// aluImmediateExec is aluExec for the n8 forms.
+func aluImmediateExec(op uint8) func(cpu *CPU) {
+	switch op {
+	case 0:
+		return func(cpu *CPU) { cpu.add(cpu.fetch8()) }
+	case 1:
+		return func(cpu *CPU) { cpu.adc(cpu.fetch8()) }
+	case 2:
+		return func(cpu *CPU) { cpu.sub(cpu.fetch8()) }
+	case 3:
+		return func(cpu *CPU) { cpu.sbc(cpu.fetch8()) }
+	case 4:
+		return func(cpu *CPU) { cpu.and(cpu.fetch8()) }
+	case 5:
+		return func(cpu *CPU) { cpu.xor(cpu.fetch8()) }
+	case 6:
+		return func(cpu *CPU) { cpu.or(cpu.fetch8()) }
+	default:
+		return func(cpu *CPU) { cpu.cp(cpu.fetch8()) }
+	}
+}
+
+// shiftExec builds the CB rotate/shift op applied to a 3-bit operand.
+func shiftExec(op, src uint8) func(cpu *CPU) {
+	if src == 6 {
+		switch op {
+		case 0:
+			return func(cpu *CPU) { cpu.writeHL(cpu.rlc(cpu.readHL())) }
+		case 1:
+			return func(cpu *CPU) { cpu.writeHL(cpu.rrc(cpu.readHL())) }
+		case 2:
+			return func(cpu *CPU) { cpu.writeHL(cpu.rl(cpu.readHL())) }
+		case 3:
+			return func(cpu *CPU) { cpu.writeHL(cpu.rr(cpu.readHL())) }
+		case 4:
+			return func(cpu *CPU) { cpu.writeHL(cpu.sla(cpu.readHL())) }
+		case 5:
+			return func(cpu *CPU) { cpu.writeHL(cpu.sra(cpu.readHL())) }
+		case 6:
+			return func(cpu *CPU) { cpu.writeHL(cpu.swap(cpu.readHL())) }
+		default:
+			return func(cpu *CPU) { cpu.writeHL(cpu.srl(cpu.readHL())) }
+		}
+	}
+
+	switch op {
+	case 0:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.rlc(*cpu.regs[src]) }
+	case 1:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.rrc(*cpu.regs[src]) }
+	case 2:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.rl(*cpu.regs[src]) }
+	case 3:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.rr(*cpu.regs[src]) }
+	case 4:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.sla(*cpu.regs[src]) }
+	case 5:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.sra(*cpu.regs[src]) }
+	case 6:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.swap(*cpu.regs[src]) }
+	default:
+		return func(cpu *CPU) { *cpu.regs[src] = cpu.srl(*cpu.regs[src]) }
+	}
+}
+
+// decodeCBOpcode builds the table entry for a CB-prefixed opcode. Cycle
+// counts include the prefix byte.
+func decodeCBOpcode(code uint8) (opcode, func(cpu *CPU)) {
+	x := code >> 6
+	y := (code >> 3) & 0x07
+	z := code & 0x07
+	mask := uint8(1) << y
+
+	cycles := uint8(8)
+	if z == 6 {
+		cycles = 16
+	}
+
+	switch x {
+	case 0:
+		flags := "Z00C"
+		if y == 6 {
+			flags = "Z000"
+		}
+		return opcode{shiftNames[y] + " " + reg8Names[z], 2, cycles, 0, flags}, shiftExec(y, z)
+	case 1:
+		op := opcode{fmt.Sprintf("BIT %d, %s", y, reg8Names[z]), 2, cycles, 0, "Z01-"}
+		if z == 6 {
+			op.cycles = 12
+			return op, func(cpu *CPU) {
+				cpu.bit(mask, cpu.readHL())
+			}
+		}
+		return op, func(cpu *CPU) {
+			cpu.bit(mask, *cpu.regs[z])
+		}
+	case 2:
+		op := opcode{fmt.Sprintf("RES %d, %s", y, reg8Names[z]), 2, cycles, 0, "----"}
+		if z == 6 {
+			return op, func(cpu *CPU) {
+				cpu.writeHL(cpu.readHL() &^ mask)
+			}
+		}
+		return op, func(cpu *CPU) {
+			*cpu.regs[z] &^= mask
+		}
+	default:
+		op := opcode{fmt.Sprintf("SET %d, %s", y, reg8Names[z]), 2, cycles, 0, "----"}
+		if z == 6 {
+			return op, func(cpu *CPU) {
+				cpu.writeHL(cpu.readHL() | mask)
+			}
+		}
+		return op, func(cpu *CPU) {
+			*cpu.regs[z] |= mask
+		}
+	}
+}
@@ -1,63 +1,321 @@
 package main
 
+// LCD modes, as reported in the low bits of STAT
+const (
+	MODE_HBLANK uint8 = 0
+	MODE_VBLANK uint8 = 1
+	MODE_OAM    uint8 = 2
+	MODE_DRAW   uint8 = 3
+)
+
+// Dot timing for each line. Drawing really takes 172-289 dots depending
+// on scrolling, the window and sprites; the shortest length is used here.
+const (
+	DOTS_PER_LINE = 456
+	OAM_DOTS      = 80
+	DRAW_DOTS     = 172
+	LINES         = 154
+	VISIBLE_LINES = 144
+)
+
+// PPU steps through each line's OAM scan, drawing and HBlank, then ten
+// lines of VBlank, in time with the CPU. Each line is rendered when its
+// drawing mode ends, so register changes between lines take effect.
 type PPU struct {
 	mmu         *MMU
-	framebuffer [144][160]uint8
+	framebuffer [144][160]uint8 // Shades 0-3, after the palettes
+	bgColors    [160]uint8      // Raw background and window color IDs for the current line
+
+	dot       int // Position within the current line
+	mode      uint8
+	statLine  bool // STAT interrupt sources ORed together; requests fire on a rising edge
+	lcdOn     bool
+	frameDone bool // Set on entering VBlank
+
+	// The window starts on the first line where LY matched WY during the
+	// frame, and draws from its own line counter, which only advances on
+	// lines where the window was actually drawn
+	windowTriggered bool
+	windowLine      uint8
+
+	lineSprites [MAX_LINE_SPRITES]sprite // Selected by the OAM scan for the current line
+}
+
+// MAX_LINE_SPRITES is how many sprites the OAM scan can select per line.
+// Any more on the same line are not drawn.
+const MAX_LINE_SPRITES = 10
+
+// sprite is one 4-byte OAM entry. Positions are stored offset so that a
+// sprite can sit partly off the top or left of the screen.
+type sprite struct {
+	y, x  uint8 // Screen position + 16 and + 8
+	tile  uint8
+	attrs uint8 // 7: behind BG colors 1-3, 6: Y flip, 5: X flip, 4: OBP1
 }
 
 func newPPU(mmu *MMU) *PPU {
 	return &PPU{
-		mmu: mmu,
+		mmu:  mmu,
+		mode: MODE_OAM,
+	}
+}
+
+// step advances the PPU by the given number of dots (T-cycles).
+func (ppu *PPU) step(cycles int) {
+	io := &ppu.mmu.io
+	if io[0x40]&0x80 == 0 {
+		if ppu.lcdOn {
+			ppu.turnOff()
+		}
+		return
+	}
+	ppu.lcdOn = true
+
+	ppu.dot += cycles
+	ly := io[0x44]
+	if ly < VISIBLE_LINES {
+		if ppu.mode == MODE_OAM && ppu.dot >= OAM_DOTS {
+			ppu.mode = MODE_DRAW
+		}
+		if ppu.mode == MODE_DRAW && ppu.dot >= OAM_DOTS+DRAW_DOTS {
+			ppu.renderLine(ly)
+			ppu.mode = MODE_HBLANK
+		}
 	}
+
+	if ppu.dot >= DOTS_PER_LINE {
+		ppu.dot -= DOTS_PER_LINE
+		ly++
+		switch {
+		case ly == VISIBLE_LINES:
+			ppu.mode = MODE_VBLANK
+			ppu.windowTriggered = false
+			ppu.windowLine = 0
+			io[0x0F] |= VBLANK_INTERRUPT
+			ppu.frameDone = true
+		case ly == LINES:
+			ly = 0
+			ppu.mode = MODE_OAM
+		case ly < VISIBLE_LINES:
+			ppu.mode = MODE_OAM
+		}
+		io[0x44] = ly
+	}
+
+	ppu.updateSTAT()
 }
 
-func (ppu *PPU) getTile(tileIndex uint8) [8][8]uint8 {
-	// Check LCD control register bit 4 for addressing mode
-	lcdControl := ppu.mmu.io[0x40] // LCDC register
+// turnOff resets to the top of the frame and blanks the screen, as the
+// LCD does when LCDC bit 7 is cleared.
+func (ppu *PPU) turnOff() {
+	ppu.lcdOn = false
+	ppu.dot = 0
+	ppu.mode = MODE_OAM
+	ppu.mmu.io[0x44] = 0
+	ppu.mmu.io[0x41] &^= 0x03 // Reads as HBlank while off
+	ppu.framebuffer = [144][160]uint8{}
+	ppu.frameDone = true
+	ppu.windowTriggered = false
+	ppu.windowLine = 0
+}
+
+// updateSTAT refreshes the mode and LY=LYC bits and requests the STAT
+// interrupt when any enabled condition becomes true.
+func (ppu *PPU) updateSTAT() {
+	io := &ppu.mmu.io
+	stat := io[0x41]&0x78 | ppu.mode
+	coincidence := io[0x44] == io[0x45]
+	if coincidence {
+		stat |= 0x04
+	}
+	io[0x41] = stat
 
-	var byteIndex int
-	if lcdControl&0x10 != 0 || lcdControl == 0 {
-		// Mode 1: 8000 method - unsigned addressing (also default if LCDC not set)
-		byteIndex = int(tileIndex) * 16
-	} else {
-		// Mode 0: 8800 method - signed addressing
-		signedIndex := int8(tileIndex)
-		byteIndex = 0x1000 + (int(signedIndex) * 16) // Base at 0x9000 in VRAM
+	line := (stat&0x08 != 0 && ppu.mode == MODE_HBLANK) ||
+		(stat&0x10 != 0 && ppu.mode == MODE_VBLANK) ||
+		(stat&0x20 != 0 && ppu.mode == MODE_OAM) ||
+		(stat&0x40 != 0 && coincidence)
+	if line && !ppu.statLine {
+		io[0x0F] |= STAT_INTERRUPT
 	}
+	ppu.statLine = line
+}
 
-	// Bounds check
-	if byteIndex < 0 || byteIndex+16 > len(ppu.mmu.vram) {
-		// Return blank tile if out of bounds
-		return [8][8]uint8{}
+// tileAddr returns the VRAM offset of a tile's data, using the addressing
+// mode selected by LCDC bit 4.
+func (ppu *PPU) tileAddr(tileIndex uint8) int {
+	if ppu.mmu.io[0x40]&0x10 != 0 {
+		// 8000 method - unsigned addressing
+		return int(tileIndex) * 16
 	}
+	// 8800 method - signed addressing, based at 0x9000
+	return 0x1000 + int(int8(tileIndex))*16
+}
+
+// tilePixel returns the 2-bit color ID at (x, y) within a tile.
+func (ppu *PPU) tilePixel(tileIndex uint8, x, y int) uint8 {
+	return ppu.pixel(ppu.tileAddr(tileIndex)+y*2, x)
+}
+
+// pixel returns the color ID of column x in the tile row at addr.
+func (ppu *PPU) pixel(addr, x int) uint8 {
+	bit := 7 - x
+	low := (ppu.mmu.vram[addr] >> bit) & 1
+	high := (ppu.mmu.vram[addr+1] >> bit) & 1
+	return high<<1 | low
+}
 
-	tileRam := ppu.mmu.vram[byteIndex : byteIndex+16]
+func (ppu *PPU) renderLine(ly uint8) {
+	if ly == ppu.mmu.io[0x4A] {
+		ppu.windowTriggered = true
+	}
 
-	var tile [8][8]uint8
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			bit := 7 - j
-			bitLow := (tileRam[2*i] >> bit) & 1
-			bitHigh := (tileRam[2*i+1] >> bit) & 1
-			tile[i][j] = (bitHigh << 1) | bitLow
+	line := &ppu.framebuffer[ly]
+	bg := &ppu.bgColors
+	if ppu.mmu.io[0x40]&0x01 != 0 {
+		ppu.renderBackground(bg, ly)
+		ppu.renderWindow(bg)
+		bgp := ppu.mmu.io[0x47]
+		for x, color := range bg {
+			line[x] = shade(bgp, color)
 		}
+	} else { // Background and window disabled: white, whatever BGP says; sprites still draw
+		*bg = [160]uint8{}
+		*line = [160]uint8{}
 	}
+	ppu.renderSprites(line, ly)
+}
 
-	return tile
+// shade looks up a color ID in a palette register, which holds a 2-bit
+// shade for each of colors 0-3, starting from the low bits.
+func shade(palette, color uint8) uint8 {
+	return palette >> (color * 2) & 0x03
 }
 
-func (ppu *PPU) Render() {
-	for tileY := 0; tileY < 18; tileY++ {
-		for tileX := 0; tileX < 20; tileX++ {
-			mapIndex := tileY*32 + tileX
-			tileIndex := ppu.mmu.vram[0x1800+mapIndex]
-			tile := ppu.getTile(tileIndex)
+// renderBackground draws one line of the 256x256 background, scrolled by
+// SCX/SCY and wrapping at its edges.
+func (ppu *PPU) renderBackground(line *[160]uint8, ly uint8) {
+	io := &ppu.mmu.io
+	mapBase := 0x1800 // 9800
+	if io[0x40]&0x08 != 0 {
+		mapBase = 0x1C00 // 9C00
+	}
 
-			for py := 0; py < 8; py++ {
-				for px := 0; px < 8; px++ {
-					ppu.framebuffer[tileY*8+py][tileX*8+px] = tile[py][px]
-				}
+	y := ly + io[0x42] // uint8 arithmetic wraps at 256
+	row := mapBase + int(y/8)*32
+	for x := 0; x < 160; x++ {
+		bgX := uint8(x) + io[0x43]
+		tileIndex := ppu.mmu.vram[row+int(bgX/8)]
+		line[x] = ppu.tilePixel(tileIndex, int(bgX%8), int(y%8))
+	}
+}
+
+// renderWindow draws the window over the background from screen column
+// WX-7 onwards. With WX below 7 the window's first columns are off the
+// left edge.
+func (ppu *PPU) renderWindow(line *[160]uint8) {
+	io := &ppu.mmu.io
+	wx := int(io[0x4B])
+	if io[0x40]&0x20 == 0 || !ppu.windowTriggered || wx > 166 {
+		return
+	}
+
+	mapBase := 0x1800 // 9800
+	if io[0x40]&0x40 != 0 {
+		mapBase = 0x1C00 // 9C00
+	}
+
+	y := ppu.windowLine
+	row := mapBase + int(y/8)*32
+	start := wx - 7
+	for x := max(start, 0); x < 160; x++ {
+		winX := x - start
+		tileIndex := ppu.mmu.vram[row+winX/8]
+		line[x] = ppu.tilePixel(tileIndex, winX%8, int(y%8))
+	}
+	ppu.windowLine++
+}
+
+// spriteHeight is 8 or 16 depending on LCDC bit 2.
+func (ppu *PPU) spriteHeight() int {
+	if ppu.mmu.io[0x40]&0x04 != 0 {
+		return 16
+	}
+	return 8
+}
+
+// scanOAM selects the first ten sprites in OAM order that cover line ly,
+// then orders them by drawing priority: the lower X coordinate wins, and
+// on a tie the sprite earlier in OAM.
+func (ppu *PPU) scanOAM(ly uint8) []sprite {
+	height := ppu.spriteHeight()
+	oam := &ppu.mmu.oam
+	n := 0
+	for i := 0; i < len(oam) && n < MAX_LINE_SPRITES; i += 4 {
+		row := int(ly) + 16 - int(oam[i])
+		if row < 0 || row >= height {
+			continue
+		}
+		ppu.lineSprites[n] = sprite{y: oam[i], x: oam[i+1], tile: oam[i+2], attrs: oam[i+3]}
+		n++
+	}
+
+	// Insertion sort keeps OAM order between equal X coordinates
+	sprites := ppu.lineSprites[:n]
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && sprites[j].x < sprites[j-1].x; j-- {
+			sprites[j], sprites[j-1] = sprites[j-1], sprites[j]
+		}
+	}
+	return sprites
+}
+
+// renderSprites draws the line's sprites over the background and window.
+// Color 0 is transparent. Where sprites overlap, the highest priority
+// sprite with a visible pixel owns it, even if that pixel then ends up
+// behind the background. Whether it does depends on the raw background
+// color ID, not the shade BGP gives it.
+func (ppu *PPU) renderSprites(line *[160]uint8, ly uint8) {
+	io := &ppu.mmu.io
+	if io[0x40]&0x02 == 0 {
+		return
+	}
+
+	height := ppu.spriteHeight()
+	var drawn [160]bool
+	for _, s := range ppu.scanOAM(ly) {
+		row := int(ly) + 16 - int(s.y)
+		if s.attrs&0x40 != 0 {
+			row = height - 1 - row
+		}
+		tile := s.tile
+		if height == 16 {
+			tile &^= 0x01 // Rows 8-15 run on into the next tile
+		}
+		addr := int(tile)*16 + row*2 // Always 8000 addressing
+
+		palette := io[0x48] // OBP0
+		if s.attrs&0x10 != 0 {
+			palette = io[0x49] // OBP1
+		}
+
+		for col := 0; col < 8; col++ {
+			x := int(s.x) - 8 + col
+			if x < 0 || x >= 160 || drawn[x] {
+				continue
+			}
+			tileX := col
+			if s.attrs&0x20 != 0 {
+				tileX = 7 - col
 			}
+			color := ppu.pixel(addr, tileX)
+			if color == 0 {
+				continue
+			}
+			drawn[x] = true
+			if s.attrs&0x80 != 0 && ppu.bgColors[x] != 0 {
+				continue
+			}
+			line[x] = shade(palette, color)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// waitForLine ticks until the start of the given line.
+func waitForLine(m *MMU, ly uint8) {
+	m.Tick(4)
+	for m.io[0x44] != ly || m.ppu.dot != 0 {
+		m.Tick(4)
+	}
+}
+
+func TestPPUModeTiming(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	waitForLine(m, 0)
+
+	for dot := 0; dot < DOTS_PER_LINE; dot += 4 {
+		want := MODE_HBLANK
+		switch {
+		case dot < OAM_DOTS:
+			want = MODE_OAM
+		case dot < OAM_DOTS+DRAW_DOTS:
+			want = MODE_DRAW
+		}
+		if mode := m.Read(0xFF41) & 0x03; mode != want {
+			t.Fatalf("dot %d: mode %d, want %d", dot, mode, want)
+		}
+		m.Tick(4)
+	}
+	if ly := m.Read(0xFF44); ly != 1 {
+		t.Errorf("LY=%d after one line, want 1", ly)
+	}
+}
+
+func TestPPUVBlankAndFrameLength(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	waitForLine(m, 0)
+	m.io[0x0F] = 0
+
+	cycles := 0
+	for m.io[0x0F]&VBLANK_INTERRUPT == 0 {
+		m.Tick(4)
+		cycles += 4
+	}
+	if cycles != VISIBLE_LINES*DOTS_PER_LINE || m.Read(0xFF41)&0x03 != MODE_VBLANK {
+		t.Errorf("VBlank after %d cycles in mode %d", cycles, m.Read(0xFF41)&0x03)
+	}
+
+	for m.io[0x44] != 0 {
+		m.Tick(4)
+		cycles += 4
+	}
+	if cycles != LINES*DOTS_PER_LINE {
+		t.Errorf("frame took %d cycles, want %d", cycles, LINES*DOTS_PER_LINE)
+	}
+}
+
+func TestPPUSTATInterrupts(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	m.Write(0xFF45, 10)   // LYC
+	m.Write(0xFF41, 0x40) // LYC interrupt only
+	m.io[0x0F] = 0
+
+	for m.io[0x44] != 10 {
+		m.Tick(4)
+		if m.io[0x0F]&STAT_INTERRUPT != 0 && m.io[0x44] != 10 {
+			t.Fatalf("STAT interrupt on line %d", m.io[0x44])
+		}
+	}
+	if m.io[0x0F]&STAT_INTERRUPT == 0 || m.Read(0xFF41)&0x04 == 0 {
+		t.Errorf("no STAT interrupt on LY=LYC, STAT=%02X", m.Read(0xFF41))
+	}
+
+	// The line stays high through the rest of the line, so no repeats
+	m.io[0x0F] = 0
+	m.Tick(200)
+	if m.io[0x0F]&STAT_INTERRUPT != 0 {
+		t.Error("STAT interrupt repeated while LY=LYC held")
+	}
+}
+
+func TestPPULCDOff(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	m.Tick(DOTS_PER_LINE * 20)
+	m.Write(0xFF40, 0x11)
+	m.Tick(4)
+	if ly, mode := m.Read(0xFF44), m.Read(0xFF41)&0x03; ly != 0 || mode != MODE_HBLANK {
+		t.Errorf("LY=%d mode %d with the LCD off", ly, mode)
+	}
+	m.Tick(DOTS_PER_LINE * 3)
+	if ly := m.Read(0xFF44); ly != 0 {
+		t.Errorf("LY advanced to %d with the LCD off", ly)
+	}
+}
+
+func TestPPURegisterChangesBetweenLines(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	// Tile 1 is solid color 3, and the whole map uses it
+	for i := 16; i < 32; i++ {
+		m.vram[i] = 0xFF
+	}
+	for i := 0x1800; i < 0x1C00; i++ {
+		m.vram[i] = 1
+	}
+	waitForLine(m, 0)
+
+	// Turn the background off halfway down the screen
+	waitForLine(m, 72)
+	m.Write(0xFF40, 0x90)
+	waitForLine(m, VISIBLE_LINES)
+
+	fb := &m.ppu.framebuffer
+	if fb[71][0] != 3 || fb[72][0] != 0 {
+		t.Errorf("lines 71 and 72 start with %d and %d, want 3 and 0", fb[71][0], fb[72][0])
+	}
+}
+
+func TestPPURendersTetris(t *testing.T) {
+	rom, err := os.ReadFile("roms/Tetris.gb")
+	if err != nil {
+		t.Skip(err)
+	}
+	m := newMMU(rom)
+	cpu := newCPU(m)
+
+	frames := 0
+	for frames < 120 {
+		m.ppu.frameDone = false
+		for !m.ppu.frameDone {
+			cpu.Step()
+			cpu.HandleInterrupts()
+		}
+		frames++
+	}
+
+	drawn := 0
+	for _, line := range m.ppu.framebuffer {
+		for _, px := range line {
+			if px != 0 {
+				drawn++
+			}
+		}
+	}
+	if drawn == 0 {
+		t.Error("nothing drawn after two seconds")
+	}
+}
+
+// newTestPatternMMU fills tile n with color n%4 and sets BGP so that
+// each color shows as the same shade.
+func newTestPatternMMU() *MMU {
+	m := newMMU(make([]byte, 0x8000))
+	m.Write(0xFF47, 0xE4)
+	for tile := 0; tile < 256; tile++ {
+		color := tile % 4
+		for row := 0; row < 8; row++ {
+			if color&1 != 0 {
+				m.vram[tile*16+row*2] = 0xFF
+			}
+			if color&2 != 0 {
+				m.vram[tile*16+row*2+1] = 0xFF
+			}
+		}
+	}
+	return m
+}
+
+func TestPPUBackgroundScroll(t *testing.T) {
+	m := newTestPatternMMU()
+	m.vram[0x1800+0] = 1       // (0, 0)
+	m.vram[0x1800+31] = 2      // (31, 0), the right edge
+	m.vram[0x1800+31*32+0] = 3 // (0, 31), the bottom edge
+	m.vram[0x1C00+0] = 3       // (0, 0) in the 9C00 map
+
+	// Scroll so that map tile (31, 31) is at the top left. Map tiles
+	// (31, 0), (0, 31) and (0, 0) then sit to its right and below it.
+	m.Write(0xFF42, 248)
+	m.Write(0xFF43, 252)
+	waitForLine(m, 0)
+	waitForLine(m, 16)
+
+	fb := &m.ppu.framebuffer
+	checks := []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 0, 0},  // Map (31, 31)
+		{4, 0, 3},  // Map (0, 31), wrapped horizontally
+		{0, 8, 2},  // Map (31, 0), wrapped vertically
+		{4, 8, 1},  // Map (0, 0)
+		{12, 8, 0}, // Map (1, 0)
+	}
+	for _, c := range checks {
+		if got := fb[c.y][c.x]; got != c.want {
+			t.Errorf("pixel (%d, %d) = %d, want %d", c.x, c.y, got, c.want)
+		}
+	}
+
+	m.Write(0xFF40, 0x99) // 9C00 map
+	m.Write(0xFF42, 0)
+	m.Write(0xFF43, 0)
+	waitForLine(m, 1)
+	if got := fb[0][0]; got != 3 {
+		t.Errorf("pixel (0, 0) = %d from the 9C00 map, want 3", got)
+	}
+}
+
+func TestPPUWindowLineCounter(t *testing.T) {
+	m := newTestPatternMMU()
+	for row := 0; row < 32; row++ {
+		for col := 0; col < 32; col++ {
+			m.vram[0x1C00+row*32+col] = uint8(row + 1) // Window map, color row+1
+		}
+	}
+	m.Write(0xFF4A, 10) // WY
+	m.Write(0xFF4B, 87) // WX, screen column 80
+	m.Write(0xFF40, 0xF1)
+	waitForLine(m, 0)
+
+	waitForLine(m, 20)
+	m.Write(0xFF40, 0xD1) // Window off for ten lines
+	waitForLine(m, 30)
+	m.Write(0xFF40, 0xF1)
+	waitForLine(m, VISIBLE_LINES)
+
+	fb := &m.ppu.framebuffer
+	checks := []struct {
+		x, y int
+		want uint8
+	}{
+		{80, 9, 0},  // Above WY
+		{79, 10, 0}, // Left of WX
+		{80, 10, 1}, // Window row 0
+		{80, 18, 2}, // Window row 1
+		{80, 25, 0}, // Window off
+		{80, 30, 2}, // Resumes at window line 10, still row 1
+		{80, 36, 3}, // Window line 16
+	}
+	for _, c := range checks {
+		if got := fb[c.y][c.x]; got != c.want {
+			t.Errorf("pixel (%d, %d) = %d, want %d", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestPPUWindowEdgeCases(t *testing.T) {
+	m := newTestPatternMMU()
+	for i := 0x1C00; i < 0x2000; i++ {
+		m.vram[i] = 1
+	}
+	m.vram[0x1C01] = 2
+	m.Write(0xFF4A, 0)
+	m.Write(0xFF4B, 3) // The first four window columns are off screen
+	m.Write(0xFF40, 0xF1)
+	waitForLine(m, 0)
+	waitForLine(m, 1)
+
+	fb := &m.ppu.framebuffer
+	if fb[0][0] != 1 || fb[0][3] != 1 || fb[0][4] != 2 {
+		t.Errorf("WX=3 drew %v, want 1 1 1 1 2", fb[0][:5])
+	}
+
+	// WY moved above LY mid-frame doesn't start the window
+	m.Write(0xFF4A, 200)
+	waitForLine(m, 0)
+	waitForLine(m, 50)
+	m.Write(0xFF4A, 40)
+	waitForLine(m, 60)
+	if got := fb[55][10]; got != 0 {
+		t.Errorf("window drawn after WY moved above LY: pixel %d", got)
+	}
+}
+
+// setSprite writes OAM entry i for a sprite at screen position (x, y).
+func setSprite(m *MMU, i, x, y int, tile, attrs uint8) {
+	copy(m.oam[i*4:], []byte{uint8(y + 16), uint8(x + 8), tile, attrs})
+}
+
+func TestPPUSprites(t *testing.T) {
+	m := newTestPatternMMU()
+	// Tile 4 has a single color 3 pixel at its top left; tiles 6 and 7
+	// are an 8x16 pair with colors 2 and 3
+	for i := 64; i < 80; i++ {
+		m.vram[i] = 0
+	}
+	m.vram[64], m.vram[65] = 0x80, 0x80
+	for i := 0x1800; i < 0x1C00; i++ {
+		m.vram[i] = 1 // Background color 1
+	}
+	m.vram[0x1800] = 0    // Color 0 under the sprite at (0, 0)
+	m.Write(0xFF48, 0xE4) // OBP0 identity
+	m.Write(0xFF49, 0x1B) // OBP1 reversed
+
+	setSprite(m, 0, 0, 0, 3, 0x80)  // Behind BG, only shows over color 0
+	setSprite(m, 1, 20, 0, 3, 0x80) // Behind BG color 1
+	setSprite(m, 2, 40, 0, 4, 0x60) // X and Y flip: pixel at (7, 7)
+	setSprite(m, 3, 60, 0, 3, 0x10) // OBP1: color 3 shows as 0
+	setSprite(m, 4, -4, 20, 3, 0)   // Half off the left edge
+	setSprite(m, 5, 80, 20, 2, 0)   // Only 8 rows tall in 8x8 mode
+	m.Write(0xFF40, 0x93)
+	waitForLine(m, 0)
+	waitForLine(m, 40)
+
+	fb := &m.ppu.framebuffer
+	checks := []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 0, 3},   // Over BG color 0
+		{8, 0, 1},   // Background beside it
+		{20, 0, 1},  // Behind BG color 1
+		{40, 0, 1},  // Flipped pixel moved away
+		{47, 7, 3},  // to the bottom right
+		{60, 0, 0},  // Through OBP1
+		{0, 20, 3},  // Right half of a clipped sprite
+		{4, 20, 1},  // Beyond it
+		{80, 27, 2}, // Last row
+		{80, 28, 1}, // Background below it
+	}
+	for _, c := range checks {
+		if got := fb[c.y][c.x]; got != c.want {
+			t.Errorf("pixel (%d, %d) = %d, want %d", c.x, c.y, got, c.want)
+		}
+	}
+
+	// 8x16 mode ignores bit 0 of the tile index, and sprites still draw
+	// with the background off
+	setSprite(m, 5, 80, 20, 7, 0)
+	m.Write(0xFF40, 0x96)
+	waitForLine(m, 0)
+	waitForLine(m, 40)
+	if fb[20][80] != 2 || fb[35][80] != 3 || fb[36][80] != 0 || fb[0][8] != 0 {
+		t.Errorf("8x16 sprite drew %d %d %d, background %d", fb[20][80], fb[35][80], fb[36][80], fb[0][8])
+	}
+
+	// And none draw with LCDC bit 1 clear
+	m.Write(0xFF40, 0x91)
+	waitForLine(m, 0)
+	waitForLine(m, 40)
+	if fb[0][0] != 0 || fb[0][60] != 1 {
+		t.Errorf("sprites drawn while disabled: %d %d", fb[0][0], fb[0][60])
+	}
+}
+
+func TestPPUSpritePriority(t *testing.T) {
+	m := newTestPatternMMU()
+	m.Write(0xFF48, 0xE4)
+
+	// Overlapping sprites: the lower X wins, then the lower OAM index
+	setSprite(m, 0, 4, 0, 2, 0)
+	setSprite(m, 1, 0, 0, 3, 0)
+	setSprite(m, 2, 20, 0, 1, 0)
+	setSprite(m, 3, 20, 0, 2, 0)
+	// A transparent pixel lets the sprite behind show through
+	setSprite(m, 4, 40, 0, 4, 0)
+	setSprite(m, 5, 41, 0, 2, 0)
+
+	// Eleven sprites on line 50: the last in OAM is dropped, even though
+	// it has the lowest X
+	for i := 0; i < 10; i++ {
+		setSprite(m, 10+i, 10+i*10, 50, 1, 0)
+	}
+	setSprite(m, 20, 0, 50, 3, 0)
+
+	m.Write(0xFF40, 0x83) // Background off
+	waitForLine(m, 0)
+	waitForLine(m, 60)
+
+	fb := &m.ppu.framebuffer
+	checks := []struct {
+		x, y int
+		want uint8
+	}{
+		{4, 0, 3},    // Sprite 1 has the lower X
+		{20, 0, 1},   // Same X: sprite 2 is first in OAM
+		{41, 0, 2},   // Tile 4 is color 0 here, so sprite 5 shows
+		{0, 50, 0},   // The eleventh sprite
+		{100, 50, 1}, // The tenth
+	}
+	for _, c := range checks {
+		if got := fb[c.y][c.x]; got != c.want {
+			t.Errorf("pixel (%d, %d) = %d, want %d", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestPPUPalettes(t *testing.T) {
+	m := newTestPatternMMU()
+	for i := 0x1800; i < 0x1C00; i++ {
+		m.vram[i] = uint8(i % 4) // Colors 0-3 across each line
+	}
+	m.Write(0xFF47, 0x1B) // BGP reversed
+	m.Write(0xFF48, 0x00) // OBP0 all white
+	m.Write(0xFF49, 0x55) // OBP1 all light gray
+
+	// Priority uses the raw color: BGP shows color 0 as black, but sprites
+	// behind the background still show through it, and not through color 3,
+	// which BGP shows as white
+	setSprite(m, 0, 4, 8, 3, 0x90)  // OBP1, behind BG, over colors 0 and 1
+	setSprite(m, 1, 28, 8, 3, 0x90) // Over colors 3 and 0
+	setSprite(m, 2, 0, 16, 3, 0)    // OBP0
+	m.Write(0xFF40, 0x93)
+	waitForLine(m, 0)
+	waitForLine(m, 30)
+
+	fb := &m.ppu.framebuffer
+	checks := []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 0, 3},  // Color 0
+		{8, 0, 2},  // Color 1
+		{24, 0, 0}, // Color 3
+		{4, 8, 1},  // Sprite over color 0
+		{8, 8, 2},  // Color 1 in front of the sprite
+		{28, 8, 0}, // Color 3, shown white, in front of the sprite
+		{32, 8, 1}, // Sprite over color 0
+		{0, 16, 0}, // Sprite through OBP0
+	}
+	for _, c := range checks {
+		if got := fb[c.y][c.x]; got != c.want {
+			t.Errorf("pixel (%d, %d) = %d, want %d", c.x, c.y, got, c.want)
+		}
+	}
+
+	// Fading out by rewriting BGP
+	m.Write(0xFF47, 0x00)
+	waitForLine(m, 1)
+	if got := fb[0][8]; got != 0 {
+		t.Errorf("color 1 shows as %d with BGP=00", got)
+	}
+
+	// With the background disabled it's white whatever BGP says
+	m.Write(0xFF47, 0xFF)
+	m.Write(0xFF40, 0x92)
+	waitForLine(m, 1)
+	if got := fb[0][8]; got != 0 {
+		t.Errorf("disabled background shows as %d", got)
+	}
+}
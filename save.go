@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long cartridge RAM has to go unwritten before it is flushed. Games
+// write saves over several frames, so flushing on every write would both
+// thrash the disk and risk catching a half-written save.
+const saveFlushDelay = time.Second
+
+// BatterySave keeps a battery-backed cartridge's RAM in a .sav file.
+type BatterySave struct {
+	path string
+	mmu  *MMU
+
+	writes    int       // mmu.ramWrites when last checked
+	lastWrite time.Time // When writes were last seen
+	dirty     bool
+	failing   bool // The last flush failed and was reported
+}
+
+// savePath returns the .sav path next to the ROM, e.g. roms/Tetris.sav.
+func savePath(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sav"
+}
+
+// openBatterySave loads an existing save into the cartridge. A missing
+// file is fine, since the game will create its own save data.
+func openBatterySave(path string, mmu *MMU) (*BatterySave, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := mmu.cart.LoadBattery(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return &BatterySave{
+		path:   path,
+		mmu:    mmu,
+		writes: mmu.ramWrites,
+	}, nil
+}
+
+// Update is called once per frame and flushes the save once writes to
+// cartridge RAM have stopped. A failed flush is retried after another
+// saveFlushDelay, and only the first error of a run of failures is
+// returned.
+func (s *BatterySave) Update(now time.Time) error {
+	if s.mmu.ramWrites != s.writes {
+		s.writes = s.mmu.ramWrites
+		s.lastWrite = now
+		s.dirty = true
+		return nil
+	}
+	if !s.dirty || now.Sub(s.lastWrite) < saveFlushDelay {
+		return nil
+	}
+	if err := s.Flush(); err != nil {
+		s.lastWrite = now
+		if s.failing {
+			return nil
+		}
+		s.failing = true
+		return err
+	}
+	s.failing = false
+	return nil
+}
+
+// Flush writes the save. It goes through a temporary file so that being
+// killed mid-write can't leave a truncated save behind.
+func (s *BatterySave) Flush() error {
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, s.mmu.cart.SaveBattery(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSavePath(t *testing.T) {
+	if got := savePath("roms/Pokemon Red.gb"); got != "roms/Pokemon Red.sav" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestBatterySaveFlushesAfterWritesStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.sav")
+	rom := newBankedROM(0x03, 2, 2) // MBC1+RAM+BATTERY, 8KB
+	m := newMMU(rom)
+	save, err := openBatterySave(path, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(0, 0)
+	m.Write(0x0000, 0x0A)
+	m.Write(0xA010, 0x42)
+	save.Update(start)
+	m.Write(0xA011, 0x43)
+	save.Update(start.Add(500 * time.Millisecond))
+
+	// Still inside the delay after the last write
+	save.Update(start.Add(1400 * time.Millisecond))
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("flushed while writes were still recent")
+	}
+
+	save.Update(start.Add(1600 * time.Millisecond))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0x2000 || data[0x10] != 0x42 || data[0x11] != 0x43 {
+		t.Errorf("saved %d bytes: %02X %02X", len(data), data[0x10], data[0x11])
+	}
+
+	// A fresh start loads it back
+	m = newMMU(rom)
+	if _, err := openBatterySave(path, m); err != nil {
+		t.Fatal(err)
+	}
+	m.Write(0x0000, 0x0A)
+	if got := m.Read(0xA011); got != 0x43 {
+		t.Errorf("read %02X after reloading, want 43", got)
+	}
+}
+
+func TestBatterySaveIgnoresRejectedWrites(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cartType uint8
+		setup    func(m *MMU)
+	}{
+		{"MBC1 RAM disabled", 0x03, func(m *MMU) {}},
+		{"MBC3 clock register", 0x10, func(m *MMU) {
+			m.Write(0x0000, 0x0A)
+			m.Write(0x4000, 0x08) // RTC seconds
+		}},
+	} {
+		path := filepath.Join(t.TempDir(), "game.sav")
+		m := newMMU(newBankedROM(tc.cartType, 2, 2))
+		save, err := openBatterySave(path, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.setup(m)
+
+		start := time.Unix(0, 0)
+		m.Write(0xA000, 0x30)
+		save.Update(start)
+		save.Update(start.Add(2 * time.Second))
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s: flushed without any RAM changing", tc.name)
+		}
+	}
+}
+
+func TestBatterySaveBacksOffAfterFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	path := filepath.Join(dir, "game.sav")
+	m := newMMU(newBankedROM(0x03, 2, 2))
+	save, err := openBatterySave(path, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(0, 0)
+	m.Write(0x0000, 0x0A)
+	m.Write(0xA000, 0x42)
+	save.Update(start)
+
+	// The directory is missing, so the flush fails. It is reported once,
+	// and retried a full delay later rather than on every frame.
+	if err := save.Update(start.Add(time.Second)); err == nil {
+		t.Fatal("no error saving into a missing directory")
+	}
+	for ms := 1016; ms <= 2008; ms += 16 {
+		if err := save.Update(start.Add(time.Duration(ms) * time.Millisecond)); err != nil {
+			t.Fatalf("error reported again at %dms: %v", ms, err)
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	save.Update(start.Add(2500 * time.Millisecond)) // Last tried at 2008ms
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("retried before the delay was up")
+	}
+	if err := save.Update(start.Add(3008 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x42 {
+		t.Errorf("saved %02X, want 42", data[0])
+	}
+}
+
+func TestBatterySaveRejectsWrongSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.sav")
+	if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openBatterySave(path, newMMU(newBankedROM(0x03, 2, 2))); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The SM83 SingleStepTests vectors (https://github.com/SingleStepTests/sm83)
+// are not vendored. Clone the repository and point SM83_TESTS at its v1
+// directory, or copy the JSON files into testdata/sm83/v1.
+const defaultSingleStepDir = "testdata/sm83/v1"
+
+type singleStepState struct {
+	PC  uint16      `json:"pc"`
+	SP  uint16      `json:"sp"`
+	A   uint8       `json:"a"`
+	B   uint8       `json:"b"`
+	C   uint8       `json:"c"`
+	D   uint8       `json:"d"`
+	E   uint8       `json:"e"`
+	F   uint8       `json:"f"`
+	H   uint8       `json:"h"`
+	L   uint8       `json:"l"`
+	IME uint8       `json:"ime"`
+	IE  *uint8      `json:"ie"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+type singleStepCase struct {
+	Name    string            `json:"name"`
+	Initial singleStepState   `json:"initial"`
+	Final   singleStepState   `json:"final"`
+	Cycles  []json.RawMessage `json:"cycles"`
+}
+
+// busCycle is one M-cycle of bus activity. Internal cycles have neither
+// read nor write set.
+type busCycle struct {
+	addr        uint16
+	val         uint8
+	read, write bool
+}
+
+func (c busCycle) String() string {
+	switch {
+	case c.read:
+		return fmt.Sprintf("read  %04X=%02X", c.addr, c.val)
+	case c.write:
+		return fmt.Sprintf("write %04X=%02X", c.addr, c.val)
+	default:
+		return "idle"
+	}
+}
+
+// flatBus is 64KB of plain RAM that records the access made in each
+// M-cycle. The CPU accesses memory first and then ticks, so each Tick
+// closes the cycle that the preceding access belongs to.
+type flatBus struct {
+	mem     [0x10000]uint8
+	pending busCycle
+	cycles  []busCycle
+}
+
+func (b *flatBus) Read(addr uint16) uint8 {
+	b.pending = busCycle{addr: addr, val: b.mem[addr], read: true}
+	return b.mem[addr]
+}
+
+func (b *flatBus) Write(addr uint16, val uint8) {
+	b.mem[addr] = val
+	b.pending = busCycle{addr: addr, val: val, write: true}
+}
+
+func (b *flatBus) Tick(cycles int) {
+	for i := 0; i < cycles/4; i++ {
+		b.cycles = append(b.cycles, b.pending)
+		b.pending = busCycle{}
+	}
+}
+
+// Opcodes whose vectors depend on system behavior beyond a flat bus.
+var singleStepSkips = map[string]string{
+	"10": "STOP resets DIV and stops the clock",
+}
+
+func singleStepDir(t *testing.T) string {
+	dir := os.Getenv("SM83_TESTS")
+	if dir == "" {
+		dir = defaultSingleStepDir
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Skipf("SingleStepTests vectors not found in %s; set SM83_TESTS to run them", dir)
+	}
+	return dir
+}
+
+func TestSingleStep(t *testing.T) {
+	dir := singleStepDir(t)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skipf("no JSON vectors in %s", dir)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			if reason, ok := singleStepSkips[name]; ok {
+				t.Skip(reason)
+			}
+			t.Parallel()
+			runSingleStepFile(t, file)
+		})
+	}
+}
+
+func runSingleStepFile(t *testing.T, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []singleStepCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatalf("parsing %s: %v", file, err)
+	}
+
+	bus := &flatBus{}
+	failures := 0
+	for _, tc := range cases {
+		if err := runSingleStepCase(bus, tc); err != nil {
+			failures++
+			if failures <= 5 { // One broken opcode would otherwise print thousands of lines
+				t.Errorf("%s: %v", tc.Name, err)
+			}
+		}
+	}
+	if failures > 0 {
+		t.Errorf("%d/%d cases failed", failures, len(cases))
+	}
+}
+
+func runSingleStepCase(bus *flatBus, tc singleStepCase) error {
+	*bus = flatBus{cycles: bus.cycles[:0]}
+	cpu := newCPU(bus)
+
+	in := tc.Initial
+	*cpu.Reg = Registers{
+		A: in.A, B: in.B, C: in.C, D: in.D, E: in.E, F: in.F, H: in.H, L: in.L,
+		SP: in.SP, PC: in.PC, IME: in.IME != 0,
+	}
+	if in.IE != nil {
+		bus.mem[0xFFFF] = *in.IE
+	}
+	for _, entry := range in.RAM {
+		bus.mem[entry[0]] = uint8(entry[1])
+	}
+
+	cpu.Step()
+
+	var diffs []string
+	check := func(name string, got, want uint16) {
+		if got != want {
+			diffs = append(diffs, fmt.Sprintf("%s=%04X want %04X", name, got, want))
+		}
+	}
+
+	out, r := tc.Final, cpu.Reg
+	check("A", uint16(r.A), uint16(out.A))
+	check("F", uint16(r.F), uint16(out.F))
+	check("B", uint16(r.B), uint16(out.B))
+	check("C", uint16(r.C), uint16(out.C))
+	check("D", uint16(r.D), uint16(out.D))
+	check("E", uint16(r.E), uint16(out.E))
+	check("H", uint16(r.H), uint16(out.H))
+	check("L", uint16(r.L), uint16(out.L))
+	check("SP", r.SP, out.SP)
+	check("PC", r.PC, out.PC)
+
+	// EI takes effect after the next instruction, which the vectors
+	// already count as enabled
+	ime := uint16(0)
+	if r.IME || cpu.imeScheduled {
+		ime = 1
+	}
+	check("IME", ime, uint16(out.IME))
+
+	for _, entry := range out.RAM {
+		check(fmt.Sprintf("[%04X]", entry[0]), uint16(bus.mem[entry[0]]), entry[1])
+	}
+
+	want, err := parseBusCycles(tc.Cycles)
+	if err != nil {
+		return err
+	}
+	if len(bus.cycles) != len(want) {
+		diffs = append(diffs, fmt.Sprintf("took %d M-cycles, want %d", len(bus.cycles), len(want)))
+	} else {
+		for i := range want {
+			got := bus.cycles[i]
+			// Only the presence and kind of an access matter on idle cycles
+			if got.read != want[i].read || got.write != want[i].write ||
+				((got.read || got.write) && (got.addr != want[i].addr || got.val != want[i].val)) {
+				diffs = append(diffs, fmt.Sprintf("cycle %d: %v, want %v", i, got, want[i]))
+			}
+		}
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("%s", strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+// parseBusCycles decodes entries of the form [addr, value, "r-m"]. Idle
+// cycles are either null or have no 'r' or 'w' in the activity string.
+func parseBusCycles(raw []json.RawMessage) ([]busCycle, error) {
+	cycles := make([]busCycle, len(raw))
+	for i, msg := range raw {
+		if string(msg) == "null" {
+			continue
+		}
+		var entry []any
+		if err := json.Unmarshal(msg, &entry); err != nil {
+			return nil, fmt.Errorf("cycle %d: %v", i, err)
+		}
+		if len(entry) != 3 {
+			return nil, fmt.Errorf("cycle %d: want 3 fields, got %d", i, len(entry))
+		}
+
+		activity, _ := entry[2].(string)
+		addr, _ := entry[0].(float64)
+		val, _ := entry[1].(float64)
+		cycles[i] = busCycle{
+			addr:  uint16(addr),
+			val:   uint8(val),
+			read:  strings.Contains(activity, "r"),
+			write: strings.Contains(activity, "w"),
+		}
+	}
+	return cycles, nil
+}
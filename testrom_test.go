@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/tabwriter"
+)
+
+// Test ROMs aren't vendored either. Point GB_TEST_ROMS at a directory of
+// Blargg (cpu_instrs, instr_timing, mem_timing) and mooneye (acceptance)
+// builds, or copy them into testdata/roms. Subdirectories are searched.
+const defaultTestROMDir = "testdata/roms"
+
+// About two minutes of emulated time, enough for the full cpu_instrs.
+const testROMCycleBudget = 4194304 * 120
+
+// serialBus captures bytes sent over the serial port, which Blargg's ROMs
+// use to print their results.
+type serialBus struct {
+	*MMU
+	output strings.Builder
+}
+
+func (b *serialBus) Write(addr uint16, val uint8) {
+	b.MMU.Write(addr, val)
+	if addr == 0xFF02 && val == 0x81 { // Transfer start with the internal clock
+		b.output.WriteByte(b.MMU.Read(0xFF01))
+	}
+}
+
+type testROMResult struct {
+	passed bool
+	detail string
+}
+
+// runTestROM runs from the post-boot state at 0100 until the ROM reports a
+// result or the cycle budget runs out.
+func runTestROM(rom []byte) testROMResult {
+	bus := &serialBus{MMU: newMMU(rom)}
+	cpu := newCPU(bus)
+
+	for cycles := 0; cycles < testROMCycleBudget; {
+		// Mooneye ROMs signal completion with LD B,B, which Blargg's also
+		// execute as an ordinary instruction
+		breakpoint := bus.Read(cpu.Reg.PC) == 0x40 && !cpu.halted && cpu.Err() == nil
+		cycles += cpu.Step()
+		if breakpoint {
+			if result, done := mooneyeResult(cpu.Reg); done {
+				return result
+			}
+		}
+		cycles += cpu.HandleInterrupts()
+
+		if err := cpu.Err(); err != nil {
+			return testROMResult{detail: err.Error()}
+		}
+		out := bus.output.String()
+		if strings.Contains(out, "Passed") {
+			return testROMResult{passed: true, detail: "Passed"}
+		}
+		if strings.Contains(out, "Failed") && strings.HasSuffix(out, "\n") {
+			return testROMResult{detail: lastLines(out, 3)}
+		}
+	}
+	return testROMResult{detail: "timed out: " + lastLines(bus.output.String(), 3)}
+}
+
+// mooneyeResult checks the registers at an LD B,B for the mooneye pass
+// and fail signatures. Any other values mean the ROM hasn't finished.
+func mooneyeResult(r *Registers) (result testROMResult, done bool) {
+	switch [6]uint8{r.B, r.C, r.D, r.E, r.H, r.L} {
+	case [6]uint8{3, 5, 8, 13, 21, 34}:
+		return testROMResult{passed: true, detail: "Fibonacci registers"}, true
+	case [6]uint8{0x42, 0x42, 0x42, 0x42, 0x42, 0x42}:
+		return testROMResult{detail: "failure registers (all 42)"}, true
+	}
+	return testROMResult{}, false
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, " / ")
+}
+
+func TestROMs(t *testing.T) {
+	dir := os.Getenv("GB_TEST_ROMS")
+	if dir == "" {
+		dir = defaultTestROMDir
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Skipf("test ROMs not found in %s; set GB_TEST_ROMS to run them", dir)
+	}
+
+	var roms []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".gb") {
+			roms = append(roms, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roms) == 0 {
+		t.Skipf("no .gb files in %s", dir)
+	}
+
+	results := make([]testROMResult, len(roms))
+	t.Run("rom", func(t *testing.T) {
+		for i, path := range roms {
+			name, _ := filepath.Rel(dir, path)
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				rom, err := loadROM(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				results[i] = runTestROM(rom)
+				if !results[i].passed {
+					t.Error(results[i].detail)
+				}
+			})
+		}
+	})
+
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	passed := 0
+	for i, path := range roms {
+		name, _ := filepath.Rel(dir, path)
+		status := "FAIL"
+		if results[i].passed {
+			status = "pass"
+			passed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status, name, results[i].detail)
+	}
+	w.Flush()
+	t.Logf("%d/%d test ROMs passed\n%s", passed, len(roms), table.String())
+}
+
+func TestRunTestROMBreakpoints(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values [6]uint8
+		passed bool
+	}{
+		{"pass", [6]uint8{3, 5, 8, 13, 21, 34}, true},
+		{"fail", [6]uint8{0x42, 0x42, 0x42, 0x42, 0x42, 0x42}, false},
+	} {
+		// An LD B,B with ordinary register values keeps running, then the
+		// registers are loaded and LD B,B ends the run
+		rom := make([]byte, 0x8000)
+		code := []byte{0x40}
+		for i, ld := range []byte{0x06, 0x0E, 0x16, 0x1E, 0x26, 0x2E} { // LD r,n8
+			code = append(code, ld, tc.values[i])
+		}
+		code = append(code, 0x40, 0x18, 0xFE) // LD B,B; JR -2
+		copy(rom[0x100:], code)
+
+		result := runTestROM(rom)
+		if result.passed != tc.passed || result.detail == "" || strings.HasPrefix(result.detail, "timed out") {
+			t.Errorf("%s: passed=%v %q", tc.name, result.passed, result.detail)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+// Timer is DIV, TIMA, TMA and TAC, all driven by the 16-bit system
+// counter whose upper byte is DIV. TIMA increments on a falling edge of
+// the counter bit selected by TAC, ANDed with the enable bit, so anything
+// that clears that signal ticks TIMA too: resetting the counter by
+// writing DIV, or changing TAC.
+type Timer struct {
+	counter uint16
+	tima    uint8
+	tma     uint8
+	tac     uint8
+
+	// TIMA reads 00 for one M-cycle after overflowing, and is then
+	// reloaded from TMA as the interrupt is requested
+	reloadPending bool
+	reloaded      bool // The M-cycle after the reload, when TIMA writes are ignored
+}
+
+// Counter bit watched for each TAC clock select: 4096, 262144, 65536 and
+// 16384 Hz
+var timerBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
+func (t *Timer) signal() bool {
+	return t.tac&0x04 != 0 && t.counter&timerBits[t.tac&0x03] != 0
+}
+
+// step advances the timer by one M-cycle and reports whether it requests
+// the timer interrupt.
+func (t *Timer) step() (interrupt bool) {
+	t.reloaded = false
+	if t.reloadPending {
+		t.tima = t.tma
+		t.reloadPending = false
+		t.reloaded = true
+		interrupt = true
+	}
+
+	before := t.signal()
+	t.counter += 4
+	t.checkEdge(before)
+	return interrupt
+}
+
+func (t *Timer) checkEdge(before bool) {
+	if before && !t.signal() {
+		t.tima++
+		if t.tima == 0 {
+			t.reloadPending = true
+		}
+	}
+}
+
+func (t *Timer) read(addr uint16) uint8 {
+	switch addr {
+	case 0xFF04:
+		return uint8(t.counter >> 8)
+	case 0xFF05:
+		return t.tima
+	case 0xFF06:
+		return t.tma
+	default:
+		return t.tac | 0xF8
+	}
+}
+
+func (t *Timer) write(addr uint16, val uint8) {
+	switch addr {
+	case 0xFF04:
+		before := t.signal()
+		t.counter = 0
+		t.checkEdge(before)
+	case 0xFF05:
+		// Writing during the overflow cycle cancels the reload; writing
+		// just after it loses to TMA
+		if !t.reloaded {
+			t.tima = val
+			t.reloadPending = false
+		}
+	case 0xFF06:
+		t.tma = val
+		if t.reloaded {
+			t.tima = val
+		}
+	case 0xFF07:
+		before := t.signal()
+		t.tac = val & 0x07
+		t.checkEdge(before)
+	}
+}
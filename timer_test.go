@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+// stepTimer runs n M-cycles and returns how many interrupts were raised.
+func stepTimer(t *Timer, n int) int {
+	interrupts := 0
+	for i := 0; i < n; i++ {
+		if t.step() {
+			interrupts++
+		}
+	}
+	return interrupts
+}
+
+func TestTimerRates(t *testing.T) {
+	for tac, mcycles := range map[uint8]int{0x04: 256, 0x05: 4, 0x06: 16, 0x07: 64} {
+		timer := &Timer{}
+		timer.write(0xFF07, tac)
+		stepTimer(timer, mcycles*10)
+		if timer.tima != 10 {
+			t.Errorf("TAC=%02X: TIMA=%d after %d M-cycles, want 10", tac, timer.tima, mcycles*10)
+		}
+	}
+
+	timer := &Timer{}
+	timer.write(0xFF07, 0x01) // Disabled
+	stepTimer(timer, 100)
+	if timer.tima != 0 {
+		t.Errorf("disabled timer counted to %d", timer.tima)
+	}
+	if div := timer.read(0xFF04); div != 1 {
+		t.Errorf("DIV=%d after 400 T-cycles, want 1", div)
+	}
+}
+
+func TestTimerOverflowReload(t *testing.T) {
+	timer := &Timer{tima: 0xFF, tma: 0x42}
+	timer.write(0xFF07, 0x05)
+
+	if n := stepTimer(timer, 4); n != 0 || timer.read(0xFF05) != 0x00 {
+		t.Fatalf("TIMA=%02X with %d interrupts on the overflow cycle, want 00 and none", timer.tima, n)
+	}
+	if n := stepTimer(timer, 1); n != 1 || timer.read(0xFF05) != 0x42 {
+		t.Errorf("TIMA=%02X with %d interrupts after the delay, want 42 and one", timer.tima, n)
+	}
+
+	// A TIMA write just after the reload is overridden, and a TMA write
+	// goes straight through to TIMA
+	timer.write(0xFF05, 0x10)
+	timer.write(0xFF06, 0x55)
+	if timer.tima != 0x55 {
+		t.Errorf("TIMA=%02X, want the new TMA 55", timer.tima)
+	}
+}
+
+func TestTimerOverflowCancelled(t *testing.T) {
+	timer := &Timer{tima: 0xFF, tma: 0x42}
+	timer.write(0xFF07, 0x05)
+	stepTimer(timer, 4)
+
+	timer.write(0xFF05, 0x10) // During the cycle TIMA reads 00
+	if n := stepTimer(timer, 1); n != 0 || timer.tima != 0x10 {
+		t.Errorf("TIMA=%02X with %d interrupts, want the written 10 and none", timer.tima, n)
+	}
+}
+
+func TestTimerFallingEdgeQuirks(t *testing.T) {
+	// Resetting DIV while the selected bit is set ticks TIMA
+	timer := &Timer{}
+	timer.write(0xFF07, 0x05) // Bit 3
+	stepTimer(timer, 2)       // Counter 8
+	timer.write(0xFF04, 0x12)
+	if timer.tima != 1 || timer.counter != 0 {
+		t.Errorf("after DIV write: TIMA=%d counter=%d, want 1 and 0", timer.tima, timer.counter)
+	}
+
+	// So does disabling the timer, or switching to a bit that's clear
+	timer = &Timer{}
+	timer.write(0xFF07, 0x05)
+	stepTimer(timer, 2)
+	timer.write(0xFF07, 0x01)
+	if timer.tima != 1 {
+		t.Errorf("after disabling: TIMA=%d, want 1", timer.tima)
+	}
+	timer.write(0xFF07, 0x05)
+	timer.write(0xFF07, 0x06) // Bit 5 is clear at counter 8
+	if timer.tima != 2 {
+		t.Errorf("after changing rate: TIMA=%d, want 2", timer.tima)
+	}
+}
+
+func TestTimerRaisesInterruptThroughMMU(t *testing.T) {
+	m := newMMU(make([]byte, 0x8000))
+	m.io[0x0F] = 0
+	m.Write(0xFF06, 0xF0)
+	m.Write(0xFF05, 0xFF)
+	m.Write(0xFF04, 0)
+	m.Write(0xFF07, 0x05)
+	m.Tick(4 * 5)
+	if m.Read(0xFF0F)&TIMER_INTERRUPT == 0 || m.Read(0xFF05) != 0xF0 {
+		t.Errorf("IF=%02X TIMA=%02X", m.Read(0xFF0F), m.Read(0xFF05))
+	}
+	if got := m.Read(0xFF07); got != 0xFD {
+		t.Errorf("TAC read %02X, want unused bits set: FD", got)
+	}
+}
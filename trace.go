@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Tracer logs the CPU state before each instruction, in the format used
+// by gameboy-doctor and many reference emulators:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+type Tracer struct {
+	w io.Writer
+
+	// Only instructions with MinPC <= PC <= MaxPC are logged
+	MinPC, MaxPC uint16
+	// Limit stops logging after this many lines; zero means no limit
+	Limit int
+
+	count int
+}
+
+func newTracer(w io.Writer) *Tracer {
+	return &Tracer{
+		w:     w,
+		MaxPC: 0xFFFF,
+	}
+}
+
+// Done reports whether the line limit has been reached.
+func (t *Tracer) Done() bool {
+	return t.Limit > 0 && t.count >= t.Limit
+}
+
+func (t *Tracer) trace(cpu *CPU) {
+	pc := cpu.Reg.PC
+	if t.Done() || pc < t.MinPC || pc > t.MaxPC {
+		return
+	}
+
+	// Peek without ticking, so tracing doesn't change timing
+	r := cpu.Reg
+	fmt.Fprintf(t.w, "A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, pc,
+		cpu.bus.Read(pc), cpu.bus.Read(pc+1), cpu.bus.Read(pc+2), cpu.bus.Read(pc+3))
+	t.count++
+}
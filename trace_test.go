@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		minPC, maxPC uint16
+		limit        int
+		want         []string
+	}{
+		{"PC range", 0x0100, 0x01FF, 0, []string{
+			"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:3E,42,C3,00",
+			"A:42 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0102 PCMEM:C3,00,02,00",
+		}},
+		{"limit", 0x0000, 0xFFFF, 3, []string{
+			"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:3E,42,C3,00",
+			"A:42 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0102 PCMEM:C3,00,02,00",
+			"A:42 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0200 PCMEM:04,00,00,00",
+		}},
+	} {
+		bus := &ramBus{}
+		copy(bus.mem[0x100:], []uint8{0x3E, 0x42, 0xC3, 0x00, 0x02}) // LD A,$42; JP $0200
+		copy(bus.mem[0x200:], []uint8{0x04, 0x00})                   // INC B; NOP
+		cpu := newCPU(bus)
+
+		var out strings.Builder
+		tracer := newTracer(&out)
+		tracer.MinPC, tracer.MaxPC, tracer.Limit = tc.minPC, tc.maxPC, tc.limit
+		cpu.tracer = tracer
+		for i := 0; i < 4; i++ {
+			cpu.Step()
+		}
+
+		if want := strings.Join(tc.want, "\n") + "\n"; out.String() != want {
+			t.Errorf("%s: got\n%swant\n%s", tc.name, out.String(), want)
+		}
+		if done := tracer.Done(); done != (tc.limit > 0) {
+			t.Errorf("%s: Done() = %v", tc.name, done)
+		}
+	}
+}